@@ -0,0 +1,269 @@
+package main
+
+/*
+#### ##     ## ########   #######  ########  ########  ######
+ ##  ###   ### ##     ## ##     ## ##     ##    ##    ##    ##
+ ##  #### #### ##     ## ##     ## ##     ##    ##    ##
+ ##  ## ### ## ########  ##     ## ########     ##     ######
+ ##  ##     ## ##        ##     ## ##   ##      ##          ##
+ ##  ##     ## ##        ##     ## ##    ##     ##    ##    ##
+#### ##     ## ##         #######  ##     ##    ##     ######
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+##     ##    ###    ########   ######
+##     ##   ## ##   ##     ## ##    ##
+##     ##  ##   ##  ##     ## ##
+##     ## ##     ## ########   ######
+ ##   ##  ######### ##   ##         ##
+  ## ##   ##     ## ##    ##  ##    ##
+   ###    ##     ## ##     ##  ######
+*/
+
+var (
+	// Columns used for audit outfiles
+	auditColumns = [...]string{"IP", "SysName", "Port", "NeighborIP", "NeighborSysName", "NeighborPort", "Issue", "Local", "Remote"}
+)
+
+/*
+######## ##    ## ########  ########  ######
+   ##     ##  ##  ##     ## ##       ##    ##
+   ##      ####   ##     ## ##       ##
+   ##       ##    ########  ######    ######
+   ##       ##    ##        ##             ##
+   ##       ##    ##        ##       ##    ##
+   ##       ##    ##        ########  ######
+*/
+
+// Identifies one end of a link between two devices.
+type linkEnd struct {
+	IPAddress string `json:"ipAddress"`
+	SysName   string `json:"sysName"`
+	Port      string `json:"port"`
+}
+
+// Stores the result of comparing the VLANs on both ends of a link.
+type auditedLink struct {
+	Local            linkEnd `json:"local"`
+	Remote           linkEnd `json:"remote"`
+	RemotePortFound  bool    `json:"remotePortFound"`
+	TaggedOnlyLocal  []int   `json:"taggedOnlyLocal"`
+	TaggedOnlyRemote []int   `json:"taggedOnlyRemote"`
+	LocalUntagged    []int   `json:"localUntagged"`
+	RemoteUntagged   []int   `json:"remoteUntagged"`
+	UntaggedMismatch bool    `json:"untaggedMismatch"`
+	Consistent       bool    `json:"consistent"`
+}
+
+// Stores the audit results of all links between devices.
+type linkAudit struct {
+	Links []auditedLink `json:"links"`
+}
+
+/*
+######## ##    ## ########  ########    ######## ##     ## ##    ##  ######   ######
+   ##     ##  ##  ##     ## ##          ##       ##     ## ###   ## ##    ## ##    ##
+   ##      ####   ##     ## ##          ##       ##     ## ####  ## ##       ##
+   ##       ##    ########  ######      ######   ##     ## ## ## ## ##        ######
+   ##       ##    ##        ##          ##       ##     ## ##  #### ##             ##
+   ##       ##    ##        ##          ##       ##     ## ##   ### ##    ## ##    ##
+   ##       ##    ##        ########    ##        #######  ##    ##  ######   ######
+*/
+
+// Returns the link as seen from its remote end.
+func (al auditedLink) Reversed() auditedLink {
+	al.Local, al.Remote = al.Remote, al.Local
+	al.TaggedOnlyLocal, al.TaggedOnlyRemote = al.TaggedOnlyRemote, al.TaggedOnlyLocal
+	al.LocalUntagged, al.RemoteUntagged = al.RemoteUntagged, al.LocalUntagged
+	return al
+}
+
+// Transforms a linkAudit struct into rows of values, one row per issue found.
+func (la *linkAudit) ToRows() []tableRow {
+	var result []tableRow
+
+	for _, link := range la.Links {
+		row := tableRow{link.Local.IPAddress, link.Local.SysName, link.Local.Port, link.Remote.IPAddress, link.Remote.SysName, link.Remote.Port}
+		if !link.RemotePortFound {
+			result = append(result, append(row, "neighbor port not found", "", ""))
+			continue
+		}
+		if len(link.TaggedOnlyLocal) > 0 {
+			result = append(result, append(row[:6:6], "tagged on local end only", joinVlanIDs(link.TaggedOnlyLocal), ""))
+		}
+		if len(link.TaggedOnlyRemote) > 0 {
+			result = append(result, append(row[:6:6], "tagged on remote end only", "", joinVlanIDs(link.TaggedOnlyRemote)))
+		}
+		if link.UntaggedMismatch {
+			result = append(result, append(row[:6:6], "untagged VLAN mismatch", joinVlanIDs(link.LocalUntagged), joinVlanIDs(link.RemoteUntagged)))
+		}
+	}
+
+	return result
+}
+
+// Transforms a linkAudit struct into a string representing JSON output.
+func (la *linkAudit) ToJSON() (string, error) {
+	json, jsonErr := json.MarshalIndent(la, "", "    ")
+	if jsonErr != nil {
+		return "", fmt.Errorf("Could not encode JSON: %s", jsonErr)
+	}
+	return string(json), nil
+}
+
+/*
+######## ##     ## ##    ##  ######   ######
+##       ##     ## ###   ## ##    ## ##    ##
+##       ##     ## ####  ## ##       ##
+######   ##     ## ## ## ## ##        ######
+##       ##     ## ##  #### ##             ##
+##       ##     ## ##   ### ##    ## ##    ##
+##        #######  ##    ##  ######   ######
+*/
+
+// Returns the device a neighbor refers to, identified by its IP address or sysName
+func findNeighborDevice(devices []singleDevice, neighbor portNeighbor) *singleDevice {
+	if neighbor.IP != "" {
+		for i := range devices {
+			if canonicalIP(devices[i].IPAddress) == canonicalIP(neighbor.IP) {
+				return &devices[i]
+			}
+		}
+	}
+	for i := range devices {
+		if sameHostname(devices[i].SysName, neighbor.SysName) {
+			return &devices[i]
+		}
+	}
+	return nil
+}
+
+// Returns the port of the remote device a neighbor entry refers to.
+// The port ID reported via LLDP/CDP is matched against names and descriptions; if that fails,
+// the neighbors seen by the remote device are searched for the local end of the link.
+func findNeighborPort(remote *singleDevice, portID string, local *singleDevice, localPort *devicePort) *devicePort {
+	for i := range remote.Ports {
+		if strings.EqualFold(remote.Ports[i].Name, portID) || strings.EqualFold(remote.Ports[i].Description, portID) {
+			return &remote.Ports[i]
+		}
+	}
+	for i := range remote.Ports {
+		for _, neighbor := range remote.Ports[i].Neighbors {
+			if findNeighborDevice([]singleDevice{*local}, neighbor) != nil && (strings.EqualFold(neighbor.Port, localPort.Name) || strings.EqualFold(neighbor.Port, localPort.Description)) {
+				return &remote.Ports[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the port that carries the VLANs of a port: the port representing its LAG, if any, or the port itself
+func vlanCarrier(dev *singleDevice, port *devicePort) *devicePort {
+	if port.LagIfIndex == 0 || port.LagIfIndex == port.Index {
+		return port
+	}
+	for i := range dev.Ports {
+		if dev.Ports[i].Index == port.LagIfIndex {
+			return &dev.Ports[i]
+		}
+	}
+	return port
+}
+
+// Returns the VLAN IDs contained in a but not in b
+func missingVlanIDs(a []int, b []int) []int {
+	var result []int
+	present := make(map[int]bool)
+	for _, vid := range b {
+		present[vid] = true
+	}
+	for _, vid := range a {
+		if !present[vid] {
+			result = append(result, vid)
+		}
+	}
+	return uniqueVlanIDs(result)
+}
+
+// Compares the VLANs on both ends of every link between two devices in the results.
+// Each link is reported once, from the end whose device IP sorts first. If the remote port of a link cannot be
+// resolved, the link is reported from the end that sorts first as well; the other end only reports unresolved
+// links to devices that do not see any unresolved link back.
+func auditLinks(results devicesWrapper) linkAudit {
+	var result linkAudit
+	var unresolvedLinks []auditedLink
+
+	seenLinks := make(map[string]bool)
+	unresolvedPeers := make(map[string]bool)
+	linkKey := func(link auditedLink) string {
+		return strings.Join([]string{link.Local.IPAddress, link.Local.Port, link.Remote.IPAddress, link.Remote.Port}, "|")
+	}
+
+	devices := results.Devices
+	for devIndex := range devices {
+		local := &devices[devIndex]
+		if !local.HasData() {
+			continue
+		}
+		for portIndex := range local.Ports {
+			localPort := &local.Ports[portIndex]
+			for _, neighbor := range localPort.Neighbors {
+				remote := findNeighborDevice(devices, neighbor)
+				if remote == nil || remote == local || !remote.HasData() {
+					continue
+				}
+
+				link := auditedLink{
+					Local:  linkEnd{IPAddress: local.IPAddress, SysName: local.SysName, Port: localPort.Name},
+					Remote: linkEnd{IPAddress: remote.IPAddress, SysName: remote.SysName, Port: neighbor.Port},
+				}
+				remotePort := findNeighborPort(remote, neighbor.Port, local, localPort)
+				if remotePort == nil {
+					if !seenLinks[linkKey(link)] {
+						seenLinks[linkKey(link)] = true
+						unresolvedPeers[link.Local.IPAddress+"|"+link.Remote.IPAddress] = true
+						unresolvedLinks = append(unresolvedLinks, link)
+					}
+					continue
+				}
+				link.Remote.Port = remotePort.Name
+
+				localVlans := vlanCarrier(local, localPort)
+				remoteVlans := vlanCarrier(remote, remotePort)
+				link.RemotePortFound = true
+				link.TaggedOnlyLocal = missingVlanIDs(localVlans.TaggedVlans, remoteVlans.TaggedVlans)
+				link.TaggedOnlyRemote = missingVlanIDs(remoteVlans.TaggedVlans, localVlans.TaggedVlans)
+				link.LocalUntagged = localVlans.UntaggedVlans
+				link.RemoteUntagged = remoteVlans.UntaggedVlans
+				link.UntaggedMismatch = !equalVlanIDs(link.LocalUntagged, link.RemoteUntagged)
+				link.Consistent = len(link.TaggedOnlyLocal) == 0 && len(link.TaggedOnlyRemote) == 0 && !link.UntaggedMismatch
+				if link.Remote.IPAddress < link.Local.IPAddress {
+					link = link.Reversed()
+				}
+
+				if !seenLinks[linkKey(link)] {
+					seenLinks[linkKey(link)] = true
+					result.Links = append(result.Links, link)
+				}
+			}
+		}
+	}
+
+	for _, link := range unresolvedLinks {
+		// Without the remote port, the link cannot be matched with the one seen from the other end
+		if link.Remote.IPAddress < link.Local.IPAddress && unresolvedPeers[link.Remote.IPAddress+"|"+link.Local.IPAddress] {
+			continue
+		}
+		result.Links = append(result.Links, link)
+	}
+	sort.SliceStable(result.Links, func(i, j int) bool { return result.Links[i].Local.IPAddress < result.Links[j].Local.IPAddress })
+
+	return result
+}
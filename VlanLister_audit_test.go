@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Returns three linked switches: core-sw1 connects to access-sw1 via a LAG and to access-sw2 via a single link
+func testAuditResults() devicesWrapper {
+	return devicesWrapper{Devices: []singleDevice{
+		{IPAddress: "10.0.0.1", SysName: "core-sw1", Status: deviceStatusOK, Ports: []devicePort{
+			{Index: 1, Name: "1:1", LagIfIndex: 1, UntaggedVlans: []int{1}, TaggedVlans: []int{10, 20, 99},
+				Neighbors: []portNeighbor{{SysName: "access-sw1.example.com", Port: "1/1"}}},
+			{Index: 2, Name: "1:2", UntaggedVlans: []int{1}, TaggedVlans: []int{10, 20, 99},
+				Neighbors: []portNeighbor{{IP: "10.0.0.3", Port: "Ethernet Port 1"}}},
+			{Index: 5, Name: "1:5", LagIfIndex: 1,
+				Neighbors: []portNeighbor{{SysName: "access-sw1", Port: "1/5"}}},
+		}},
+		{IPAddress: "10.0.0.2", SysName: "access-sw1", Status: deviceStatusOK, Ports: []devicePort{
+			{Index: 1, Name: "1/1", LagIfIndex: 6144, UntaggedVlans: []int{1}, TaggedVlans: []int{10, 20, 99},
+				Neighbors: []portNeighbor{{SysName: "core-sw1", Port: "1:1"}}},
+			{Index: 5, Name: "1/5", LagIfIndex: 6144, UntaggedVlans: []int{1}, TaggedVlans: []int{10, 20, 99},
+				Neighbors: []portNeighbor{{SysName: "core-sw1", Port: "1:5"}}},
+			{Index: 6144, Name: "Mlt1", IsLag: true, UntaggedVlans: []int{1}, TaggedVlans: []int{10, 20, 99}},
+		}},
+		{IPAddress: "10.0.0.3", SysName: "access-sw2", Status: deviceStatusOK, Ports: []devicePort{
+			{Index: 1, Name: "1/1", Description: "Ethernet Port 1", UntaggedVlans: []int{10}, TaggedVlans: []int{20, 30},
+				Neighbors: []portNeighbor{{SysName: "core-sw1", Port: "1:2"}}},
+			{Index: 2, Name: "1/2", Neighbors: []portNeighbor{{SysName: "unmanaged-sw", Port: "1"}}},
+		}},
+		{IPAddress: "10.1.0.1", SysName: "branch-sw1", Status: deviceStatusFailed},
+	}}
+}
+
+func TestAuditLinks(t *testing.T) {
+	audit := auditLinks(testAuditResults())
+
+	if len(audit.Links) != 3 {
+		t.Fatalf("auditLinks() found %d link(s), want 3: %+v", len(audit.Links), audit.Links)
+	}
+	links := make(map[string]auditedLink)
+	for _, link := range audit.Links {
+		links[link.Local.Port] = link
+	}
+	for _, localPort := range []string{"1:1", "1:5"} {
+		if link := links[localPort]; !link.Consistent || link.Remote.SysName != "access-sw1" {
+			t.Errorf("link from %s is %+v, want consistent link to access-sw1", localPort, link)
+		}
+	}
+
+	mismatch := links["1:2"]
+	if mismatch.Remote.SysName != "access-sw2" || mismatch.Remote.Port != "1/1" || mismatch.Consistent {
+		t.Fatalf("link to access-sw2 is %+v, want inconsistent link to port 1/1", mismatch)
+	}
+	if !reflect.DeepEqual(mismatch.TaggedOnlyLocal, []int{10, 99}) || !reflect.DeepEqual(mismatch.TaggedOnlyRemote, []int{30}) || !mismatch.UntaggedMismatch {
+		t.Errorf("link to access-sw2 has tagged only local %v, tagged only remote %v and untagged mismatch %t", mismatch.TaggedOnlyLocal, mismatch.TaggedOnlyRemote, mismatch.UntaggedMismatch)
+	}
+
+	rows := audit.ToRows()
+	if len(rows) != 3 {
+		t.Errorf("ToRows() returned %d row(s), want 3: %v", len(rows), rows)
+	}
+}
+
+func TestAuditLinksRemotePortNotFound(t *testing.T) {
+	results := testAuditResults()
+	results.Devices[2].Ports[0].Neighbors = nil
+	results.Devices[0].Ports[1].Neighbors[0].Port = "unknown"
+
+	audit := auditLinks(results)
+	for _, link := range audit.Links {
+		if link.Remote.SysName != "access-sw2" {
+			continue
+		}
+		if link.RemotePortFound || link.Consistent || link.Remote.Port != "unknown" {
+			t.Errorf("link to access-sw2 is %+v, want remote port not found", link)
+		}
+		return
+	}
+	t.Errorf("auditLinks() did not report the link to access-sw2")
+}
+
+func TestAuditLinksReportedFromFirstEnd(t *testing.T) {
+	results := testAuditResults()
+	results.Devices[0], results.Devices[2] = results.Devices[2], results.Devices[0]
+
+	audit := auditLinks(results)
+	if len(audit.Links) != 3 {
+		t.Fatalf("auditLinks() found %d link(s), want 3: %+v", len(audit.Links), audit.Links)
+	}
+	for _, link := range audit.Links {
+		if link.Local.SysName != "core-sw1" {
+			t.Errorf("link %+v is not reported from core-sw1", link)
+		}
+		if link.Remote.SysName == "access-sw2" && (!reflect.DeepEqual(link.TaggedOnlyLocal, []int{10, 99}) || !reflect.DeepEqual(link.TaggedOnlyRemote, []int{30})) {
+			t.Errorf("link to access-sw2 has tagged only local %v and tagged only remote %v, want [10 99] and [30]", link.TaggedOnlyLocal, link.TaggedOnlyRemote)
+		}
+	}
+}
+
+func TestAuditLinksUnresolvedFromBothEnds(t *testing.T) {
+	results := testAuditResults()
+	results.Devices[0].Ports[1].Neighbors[0].Port = "unknown"
+	results.Devices[2].Ports[0].Neighbors[0].Port = "unknown"
+
+	var unresolved []auditedLink
+	for _, link := range auditLinks(results).Links {
+		if link.Remote.SysName == "access-sw2" || link.Local.SysName == "access-sw2" {
+			unresolved = append(unresolved, link)
+		}
+	}
+	if len(unresolved) != 1 {
+		t.Fatalf("auditLinks() reported the link to access-sw2 %d time(s), want once: %+v", len(unresolved), unresolved)
+	}
+	if link := unresolved[0]; link.Local.SysName != "core-sw1" || link.RemotePortFound {
+		t.Errorf("link to access-sw2 is %+v, want unresolved link reported from core-sw1", link)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+/*
+#### ##     ## ########   #######  ########  ########  ######
+ ##  ###   ### ##     ## ##     ## ##     ##    ##    ##    ##
+ ##  #### #### ##     ## ##     ## ##     ##    ##    ##
+ ##  ## ### ## ########  ##     ## ########     ##     ######
+ ##  ##     ## ##        ##     ## ##   ##      ##          ##
+ ##  ##     ## ##        ##     ## ##    ##     ##    ##    ##
+#### ##     ## ##         #######  ##     ##    ##     ######
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+/*
+##     ##    ###    ########   ######
+##     ##   ## ##   ##     ## ##    ##
+##     ##  ##   ##  ##     ## ##
+##     ## ##     ## ########   ######
+ ##   ##  ######### ##   ##         ##
+  ## ##   ##     ## ##    ##  ##    ##
+   ###    ##     ## ##     ##  ######
+*/
+
+var (
+	// Receives every device that was queried successfully; nil if no checkpoint is used
+	checkpoint *deviceCheckpoint
+	// Devices read from the checkpoint when resuming, keyed by IP address; nil if not resuming
+	resumedDevices map[string]singleDevice
+)
+
+/*
+######## ##    ## ########  ########  ######
+   ##     ##  ##  ##     ## ##       ##    ##
+   ##      ####   ##     ## ##       ##
+   ##       ##    ########  ######    ######
+   ##       ##    ##        ##             ##
+   ##       ##    ##        ##       ##    ##
+   ##       ##    ##        ########  ######
+*/
+
+// Appends devices to a checkpoint file as JSON, one device per line.
+type deviceCheckpoint struct {
+	mutex    sync.Mutex
+	filename string
+	file     *os.File
+}
+
+/*
+######## ##    ## ########  ########    ######## ##     ## ##    ##  ######   ######
+   ##     ##  ##  ##     ## ##          ##       ##     ## ###   ## ##    ## ##    ##
+   ##      ####   ##     ## ##          ##       ##     ## ####  ## ##       ##
+   ##       ##    ########  ######      ######   ##     ## ## ## ## ##        ######
+   ##       ##    ##        ##          ##       ##     ## ##  #### ##             ##
+   ##       ##    ##        ##          ##       ##     ## ##   ### ##    ## ##    ##
+   ##       ##    ##        ########    ##        #######  ##    ##  ######   ######
+*/
+
+// Appends a single device to the checkpoint file and syncs it to disk; safe for concurrent use.
+func (dc *deviceCheckpoint) Add(device singleDevice) error {
+	jsonData, jsonErr := json.Marshal(device)
+	if jsonErr != nil {
+		return fmt.Errorf("Could not encode JSON: %s", jsonErr)
+	}
+
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+	if _, writeErr := dc.file.Write(append(jsonData, '\n')); writeErr != nil {
+		return fmt.Errorf("Could not write to checkpoint <%s>: %s", dc.filename, writeErr)
+	}
+	if syncErr := dc.file.Sync(); syncErr != nil {
+		return fmt.Errorf("Could not sync checkpoint <%s>: %s", dc.filename, syncErr)
+	}
+	return nil
+}
+
+// Closes the checkpoint file.
+func (dc *deviceCheckpoint) Close() error {
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+	return dc.file.Close()
+}
+
+/*
+######## ##     ## ##    ##  ######   ######
+##       ##     ## ###   ## ##    ## ##    ##
+##       ##     ## ####  ## ##       ##
+######   ##     ## ## ## ## ##        ######
+##       ##     ## ##  #### ##             ##
+##       ##     ## ##   ### ##    ## ##    ##
+##        #######  ##    ##  ######   ######
+*/
+
+// Opens a checkpoint file; existing content is kept when resuming and discarded otherwise
+func openCheckpoint(filename string, resume bool) (*deviceCheckpoint, error) {
+	fileFlags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resume {
+		fileFlags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	fileHandle, fileErr := os.OpenFile(filename, fileFlags, 0644)
+	if fileErr != nil {
+		return nil, fmt.Errorf("could not open checkpoint: %s", fileErr)
+	}
+	return &deviceCheckpoint{filename: filename, file: fileHandle}, nil
+}
+
+// Reads all devices stored in a checkpoint file, keyed by IP address.
+// A missing file results in an empty checkpoint; an incomplete last line, as left by a crash, is ignored.
+func readCheckpoint(filename string) (map[string]singleDevice, error) {
+	devices := make(map[string]singleDevice)
+
+	fileHandle, fileErr := os.Open(filename)
+	if os.IsNotExist(fileErr) {
+		return devices, nil
+	}
+	if fileErr != nil {
+		return nil, fmt.Errorf("could not open checkpoint: %s", fileErr)
+	}
+	defer fileHandle.Close()
+
+	lineNumber := 0
+	lineScanner := bufio.NewScanner(fileHandle)
+	lineScanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for lineScanner.Scan() {
+		lineNumber++
+		if len(lineScanner.Bytes()) == 0 {
+			continue
+		}
+		var device singleDevice
+		if jsonErr := json.Unmarshal(lineScanner.Bytes(), &device); jsonErr != nil {
+			stdErr.Printf("Ignoring line %d of checkpoint <%s>: %s\n", lineNumber, filename, jsonErr)
+			continue
+		}
+		devices[canonicalIP(device.IPAddress)] = device
+	}
+	if scanErr := lineScanner.Err(); scanErr != nil {
+		return nil, fmt.Errorf("could not read checkpoint: %s", scanErr)
+	}
+
+	return devices, nil
+}
+
+// Splits a list of device IPs into the devices found in the checkpoint and those that still have to be processed
+func splitResumedDevices(ipList []string, resumedDevices map[string]singleDevice) ([]string, []singleDevice) {
+	var pendingIPs []string
+	var finishedDevices []singleDevice
+
+	for _, deviceIP := range ipList {
+		if device, found := resumedDevices[canonicalIP(deviceIP)]; found {
+			finishedDevices = append(finishedDevices, device)
+			continue
+		}
+		pendingIPs = append(pendingIPs, deviceIP)
+	}
+
+	return pendingIPs, finishedDevices
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	xmcnbiclient "gitlab.com/rbrt-weiler/go-module-xmcnbiclient"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+
+	written, openErr := openCheckpoint(filename, false)
+	if openErr != nil {
+		t.Fatalf("openCheckpoint() returned error: %s", openErr)
+	}
+	for _, device := range []singleDevice{
+		{ID: 1, IPAddress: "192.0.2.1", Status: deviceStatusOK},
+		{ID: 2, IPAddress: "192.0.2.2", Status: deviceStatusOK},
+		{ID: 3, IPAddress: "192.0.2.1", Status: deviceStatusOK, SysName: "updated"},
+	} {
+		if addErr := written.Add(device); addErr != nil {
+			t.Fatalf("Add() returned error: %s", addErr)
+		}
+	}
+	written.Close()
+
+	// Simulate a crash while the last line was written
+	fileHandle, _ := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+	fileHandle.WriteString(`{"id":4,"ipAddress":"192.0.2.4"`)
+	fileHandle.Close()
+
+	devices, readErr := readCheckpoint(filename)
+	if readErr != nil {
+		t.Fatalf("readCheckpoint() returned error: %s", readErr)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("readCheckpoint() returned %d device(s), want 2", len(devices))
+	}
+	if devices["192.0.2.1"].SysName != "updated" {
+		t.Errorf("readCheckpoint() did not keep the latest entry for 192.0.2.1")
+	}
+
+	pendingIPs, finishedDevices := splitResumedDevices([]string{"192.0.2.1", "192.0.2.3"}, devices)
+	if len(pendingIPs) != 1 || pendingIPs[0] != "192.0.2.3" {
+		t.Errorf("splitResumedDevices() returned pending %v, want [192.0.2.3]", pendingIPs)
+	}
+	if len(finishedDevices) != 1 || finishedDevices[0].IPAddress != "192.0.2.1" {
+		t.Errorf("splitResumedDevices() returned finished %v, want 192.0.2.1", finishedDevices)
+	}
+}
+
+func TestReadCheckpointMissing(t *testing.T) {
+	devices, readErr := readCheckpoint(filepath.Join(t.TempDir(), "missing.jsonl"))
+	if readErr != nil {
+		t.Fatalf("readCheckpoint() returned error: %s", readErr)
+	}
+	if len(devices) != 0 {
+		t.Errorf("readCheckpoint() returned %d device(s), want 0", len(devices))
+	}
+}
+
+func TestQueryDevicesWritesCheckpoint(t *testing.T) {
+	var client xmcnbiclient.NBIClient
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data":{"network":{"device":{"id":1,"up":true,"ip":"192.0.2.1"},"deviceVlans":[]}}}`)
+	}))
+	t.Cleanup(server.Close)
+	configureTestXMC(t, server, "")
+	config.BasicAuth = true
+	config.Workers = 1
+	initializeClient(&client)
+
+	filename := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	var openErr error
+	checkpoint, openErr = openCheckpoint(filename, false)
+	if openErr != nil {
+		t.Fatalf("openCheckpoint() returned error: %s", openErr)
+	}
+	defer func() { checkpoint = nil }()
+
+	queryDevices(context.Background(), &client, []string{"192.0.2.1"})
+	checkpoint.Close()
+
+	devices, readErr := readCheckpoint(filename)
+	if readErr != nil {
+		t.Fatalf("readCheckpoint() returned error: %s", readErr)
+	}
+	if len(devices) != 1 {
+		t.Errorf("checkpoint contains %d device(s), want 1", len(devices))
+	}
+}
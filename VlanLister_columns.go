@@ -0,0 +1,294 @@
+package main
+
+/*
+#### ##     ## ########   #######  ########  ########  ######
+ ##  ###   ### ##     ## ##     ## ##     ##    ##    ##    ##
+ ##  #### #### ##     ## ##     ## ##     ##    ##    ##
+ ##  ## ### ## ########  ##     ## ########     ##     ######
+ ##  ##     ## ##        ##     ## ##   ##      ##          ##
+ ##  ##     ## ##        ##     ## ##    ##     ##    ##    ##
+#### ##     ## ##         #######  ##     ##    ##     ######
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+##     ##    ###    ########   ######
+##     ##   ## ##   ##     ## ##    ##
+##     ##  ##   ##  ##     ## ##
+##     ## ##     ## ########   ######
+ ##   ##  ######### ##   ##         ##
+  ## ##   ##     ## ##    ##  ##    ##
+   ###    ##     ## ##     ##  ######
+*/
+
+var (
+	// Names of all columns that can be selected for outfiles, in the order they are documented
+	availableColumns = [...]string{
+		"ID", "QueriedAt", "BaseMac", "IP", "SysUpDown", "SysName", "SysLocation", "NickName",
+		"Status", "Error", "Refresh",
+		"IfIndex", "IfName", "IfMAC", "IfAdminStatus", "IfStatus",
+		"IfAlias", "IfDescr", "IfSpeed", "IfDuplex", "IfType", "Lag", "LagMembers",
+		"Uplink", "Neighbor", "NeighborPort", "NeighborIP",
+		"Untagged", "Tagged", "UntaggedNames", "TaggedNames", "VlanTypes", "VlanIPs",
+		"Forbidden", "RawVlans",
+	}
+	// Functions that render the value of each column
+	columnRenderers = map[string]columnRenderer{
+		"ID":          func(sd *singleDevice, port *devicePort) interface{} { return sd.ID },
+		"QueriedAt":   func(sd *singleDevice, port *devicePort) interface{} { return sd.QueriedAt },
+		"BaseMac":     func(sd *singleDevice, port *devicePort) interface{} { return sd.BaseMAC },
+		"IP":          func(sd *singleDevice, port *devicePort) interface{} { return sd.IPAddress },
+		"SysName":     func(sd *singleDevice, port *devicePort) interface{} { return sd.SysName },
+		"SysLocation": func(sd *singleDevice, port *devicePort) interface{} { return sd.SysLocation },
+		"NickName":    func(sd *singleDevice, port *devicePort) interface{} { return sd.NickName },
+		"Status":      func(sd *singleDevice, port *devicePort) interface{} { return sd.Status },
+		"Error":       func(sd *singleDevice, port *devicePort) interface{} { return sd.Error },
+		"Refresh":     func(sd *singleDevice, port *devicePort) interface{} { return sd.Refresh },
+		"SysUpDown": func(sd *singleDevice, port *devicePort) interface{} {
+			if sd.Up {
+				return "up"
+			}
+			return "down"
+		},
+		"IfIndex": func(sd *singleDevice, port *devicePort) interface{} {
+			if port == nil {
+				return ""
+			}
+			return port.Index
+		},
+		"IfName": func(sd *singleDevice, port *devicePort) interface{} {
+			if port == nil {
+				return "SYSTEM"
+			}
+			return port.Name
+		},
+		"IfMAC": func(sd *singleDevice, port *devicePort) interface{} {
+			if port == nil {
+				return ""
+			}
+			return port.MACAddress
+		},
+		"IfAdminStatus": func(sd *singleDevice, port *devicePort) interface{} {
+			if port == nil {
+				return "N/A"
+			}
+			return port.AdminStatus
+		},
+		"IfStatus": func(sd *singleDevice, port *devicePort) interface{} {
+			if port == nil {
+				return "N/A"
+			}
+			return port.OperStatus
+		},
+		"IfAlias": func(sd *singleDevice, port *devicePort) interface{} {
+			if port == nil {
+				return ""
+			}
+			return port.Alias
+		},
+		"IfDescr": func(sd *singleDevice, port *devicePort) interface{} {
+			if port == nil {
+				return ""
+			}
+			return port.Description
+		},
+		"IfSpeed": func(sd *singleDevice, port *devicePort) interface{} {
+			if port == nil {
+				return ""
+			}
+			return port.Speed
+		},
+		"IfDuplex": func(sd *singleDevice, port *devicePort) interface{} {
+			if port == nil {
+				return ""
+			}
+			return port.Duplex
+		},
+		"IfType": func(sd *singleDevice, port *devicePort) interface{} {
+			if port == nil {
+				return ""
+			}
+			return port.Type
+		},
+		"Lag": func(sd *singleDevice, port *devicePort) interface{} {
+			if port == nil {
+				return ""
+			}
+			return port.Lag
+		},
+		"LagMembers": func(sd *singleDevice, port *devicePort) interface{} {
+			if port == nil {
+				return ""
+			}
+			return strings.Join(port.LagMembers, ",")
+		},
+		"Uplink": func(sd *singleDevice, port *devicePort) interface{} {
+			if port == nil {
+				return ""
+			}
+			if port.Uplink {
+				return "yes"
+			}
+			return "no"
+		},
+		"Neighbor": func(sd *singleDevice, port *devicePort) interface{} {
+			return joinNeighbors(port, func(neighbor portNeighbor) string { return neighbor.SysName })
+		},
+		"NeighborPort": func(sd *singleDevice, port *devicePort) interface{} {
+			return joinNeighbors(port, func(neighbor portNeighbor) string { return neighbor.Port })
+		},
+		"NeighborIP": func(sd *singleDevice, port *devicePort) interface{} {
+			return joinNeighbors(port, func(neighbor portNeighbor) string { return neighbor.IP })
+		},
+		"Untagged": func(sd *singleDevice, port *devicePort) interface{} {
+			return joinVlanIDs(rowUntaggedVlans(sd, port))
+		},
+		"Tagged": func(sd *singleDevice, port *devicePort) interface{} {
+			return joinVlanIDs(rowTaggedVlans(sd, port))
+		},
+		"Forbidden": func(sd *singleDevice, port *devicePort) interface{} {
+			if port == nil {
+				return ""
+			}
+			return joinVlanIDs(port.ForbiddenVlans)
+		},
+		"RawVlans": func(sd *singleDevice, port *devicePort) interface{} {
+			if port == nil {
+				return ""
+			}
+			return strings.Join(port.RawVlans, ",")
+		},
+		"UntaggedNames": func(sd *singleDevice, port *devicePort) interface{} {
+			return joinVlanDetails(sd, rowUntaggedVlans(sd, port), func(vlan deviceVlan) string { return vlan.Name })
+		},
+		"TaggedNames": func(sd *singleDevice, port *devicePort) interface{} {
+			return joinVlanDetails(sd, rowTaggedVlans(sd, port), func(vlan deviceVlan) string { return vlan.Name })
+		},
+		"VlanTypes": func(sd *singleDevice, port *devicePort) interface{} {
+			return joinVlanDetails(sd, append(rowUntaggedVlans(sd, port), rowTaggedVlans(sd, port)...), func(vlan deviceVlan) string { return vlan.Type })
+		},
+		"VlanIPs": func(sd *singleDevice, port *devicePort) interface{} {
+			var vlanIDs []int
+			for _, vid := range append(rowUntaggedVlans(sd, port), rowTaggedVlans(sd, port)...) {
+				if vlan, found := sd.FindVlan(vid); found && vlan.PrimaryIP != "" {
+					vlanIDs = append(vlanIDs, vid)
+				}
+			}
+			return joinVlanDetails(sd, vlanIDs, func(vlan deviceVlan) string { return fmt.Sprintf("%s/%s", vlan.PrimaryIP, vlan.Netmask) })
+		},
+	}
+)
+
+/*
+######## ##    ## ########  ########  ######
+   ##     ##  ##  ##     ## ##       ##    ##
+   ##      ####   ##     ## ##       ##
+   ##       ##    ########  ######    ######
+   ##       ##    ##        ##             ##
+   ##       ##    ##        ##       ##    ##
+   ##       ##    ##        ########  ######
+*/
+
+// Renders the value of a single column for a device and one of its ports.
+// port is nil for the SYSTEM row that represents the device itself.
+type columnRenderer func(sd *singleDevice, port *devicePort) interface{}
+
+/*
+######## ##     ## ##    ##  ######   ######
+##       ##     ## ###   ## ##    ## ##    ##
+##       ##     ## ####  ## ##       ##
+######   ##     ## ## ## ## ##        ######
+##       ##     ## ##  #### ##             ##
+##       ##     ## ##   ### ##    ## ##    ##
+##        #######  ##    ##  ######   ######
+*/
+
+// Returns the untagged VLANs of a row; the SYSTEM row has none
+func rowUntaggedVlans(sd *singleDevice, port *devicePort) []int {
+	if port == nil {
+		return nil
+	}
+	return port.UntaggedVlans
+}
+
+// Returns the tagged VLANs of a row; the SYSTEM row lists all VLANs of the device
+func rowTaggedVlans(sd *singleDevice, port *devicePort) []int {
+	if port == nil {
+		var vlanIDs []int
+		for _, vlan := range sd.Vlans {
+			vlanIDs = append(vlanIDs, vlan.ID)
+		}
+		return vlanIDs
+	}
+	return port.TaggedVlans
+}
+
+// Returns a comma-separated representation of VLAN IDs along with a detail, e.g. "210(Voice)"
+func joinVlanDetails(sd *singleDevice, vlanIDs []int, detail func(deviceVlan) string) string {
+	var result []string
+	for _, vid := range vlanIDs {
+		vlan, found := sd.FindVlan(vid)
+		if !found || detail(vlan) == "" {
+			result = append(result, strconv.Itoa(vid))
+			continue
+		}
+		result = append(result, fmt.Sprintf("%d(%s)", vid, detail(vlan)))
+	}
+	return strings.Join(result, ",")
+}
+
+// Joins names with commas, starting a new line before a line would exceed width characters
+func wrapNames(names []string, width int) []string {
+	var lines []string
+	var line string
+	for _, name := range names {
+		if line != "" && len(line)+len(", ")+len(name)+len(",") > width {
+			lines = append(lines, line+",")
+			line = ""
+		}
+		if line != "" {
+			line += ", "
+		}
+		line += name
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Parses a comma-separated list of column names and validates each of them
+func parseColumns(columnList string) ([]string, error) {
+	var columns []string
+	for _, column := range strings.Split(columnList, ",") {
+		column = strings.TrimSpace(column)
+		if column == "" {
+			continue
+		}
+		if _, found := columnRenderers[column]; !found {
+			return nil, fmt.Errorf("unknown column <%s>", column)
+		}
+		columns = append(columns, column)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no columns given")
+	}
+	return columns, nil
+}
+
+// Returns the columns chosen by the user or the given defaults if none were chosen
+func selectColumns(defaults []string) []string {
+	if config.Columns == "" {
+		return defaults
+	}
+	columns, columnsErr := parseColumns(config.Columns)
+	if columnsErr != nil {
+		return defaults
+	}
+	return columns
+}
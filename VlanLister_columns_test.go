@@ -0,0 +1,25 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWrapNames(t *testing.T) {
+	got := wrapNames([]string{"ID", "QueriedAt", "BaseMac", "IP", "SysName"}, 20)
+	want := []string{"ID, QueriedAt,", "BaseMac, IP,", "SysName"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrapNames() = %q, want %q", got, want)
+	}
+
+	lines := wrapNames(availableColumns[:], 74)
+	if joined := strings.Join(lines, " "); joined != strings.Join(availableColumns[:], ", ") {
+		t.Errorf("wrapNames() lost columns: %s", joined)
+	}
+	for _, line := range lines {
+		if len(line) > 74 {
+			t.Errorf("wrapNames() returned line of %d characters: %s", len(line), line)
+		}
+	}
+}
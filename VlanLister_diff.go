@@ -0,0 +1,281 @@
+package main
+
+/*
+#### ##     ## ########   #######  ########  ########  ######
+ ##  ###   ### ##     ## ##     ## ##     ##    ##    ##    ##
+ ##  #### #### ##     ## ##     ## ##     ##    ##    ##
+ ##  ## ### ## ########  ##     ## ########     ##     ######
+ ##  ##     ## ##        ##     ## ##   ##      ##          ##
+ ##  ##     ## ##        ##     ## ##    ##     ##    ##    ##
+#### ##     ## ##         #######  ##     ##    ##     ######
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+/*
+##     ##    ###    ########   ######
+##     ##   ## ##   ##     ## ##    ##
+##     ##  ##   ##  ##     ## ##
+##     ## ##     ## ########   ######
+ ##   ##  ######### ##   ##         ##
+  ## ##   ##     ## ##    ##  ##    ##
+   ###    ##     ## ##     ##  ######
+*/
+
+var (
+	// Columns used for diff outfiles
+	diffColumns = [...]string{"IP", "SysName", "Object", "Name", "Change", "Attribute", "Old", "New"}
+	// The snapshot that current results are compared against; loaded on first use
+	diffBaseline     devicesWrapper
+	diffBaselineErr  error
+	diffBaselineOnce sync.Once
+)
+
+/*
+######## ##    ## ########  ########  ######
+   ##     ##  ##  ##     ## ##       ##    ##
+   ##      ####   ##     ## ##       ##
+   ##       ##    ########  ######    ######
+   ##       ##    ##        ##             ##
+   ##       ##    ##        ##       ##    ##
+   ##       ##    ##        ########  ######
+*/
+
+// Identifies a device that exists in only one of two snapshots.
+type diffDevice struct {
+	IPAddress string `json:"ipAddress"`
+	SysName   string `json:"sysName"`
+}
+
+// Stores the old and new state of a port that exists in both snapshots.
+type portDiff struct {
+	Name             string `json:"name"`
+	OldUntaggedVlans []int  `json:"oldUntaggedVlans"`
+	NewUntaggedVlans []int  `json:"newUntaggedVlans"`
+	OldTaggedVlans   []int  `json:"oldTaggedVlans"`
+	NewTaggedVlans   []int  `json:"newTaggedVlans"`
+	OldOperStatus    string `json:"oldOperStatus"`
+	NewOperStatus    string `json:"newOperStatus"`
+	OldAlias         string `json:"oldAlias"`
+	NewAlias         string `json:"newAlias"`
+}
+
+// Stores the differences of a device that exists in both snapshots.
+type deviceDiff struct {
+	IPAddress    string       `json:"ipAddress"`
+	SysName      string       `json:"sysName"`
+	OldStatus    string       `json:"oldStatus"`
+	NewStatus    string       `json:"newStatus"`
+	AddedVlans   []deviceVlan `json:"addedVlans"`
+	RemovedVlans []deviceVlan `json:"removedVlans"`
+	AddedPorts   []string     `json:"addedPorts"`
+	RemovedPorts []string     `json:"removedPorts"`
+	ChangedPorts []portDiff   `json:"changedPorts"`
+}
+
+// Stores all differences between two snapshots.
+type snapshotDiff struct {
+	AddedDevices   []diffDevice `json:"addedDevices"`
+	RemovedDevices []diffDevice `json:"removedDevices"`
+	ChangedDevices []deviceDiff `json:"changedDevices"`
+}
+
+/*
+######## ##    ## ########  ########    ######## ##     ## ##    ##  ######   ######
+   ##     ##  ##  ##     ## ##          ##       ##     ## ###   ## ##    ## ##    ##
+   ##      ####   ##     ## ##          ##       ##     ## ####  ## ##       ##
+   ##       ##    ########  ######      ######   ##     ## ## ## ## ##        ######
+   ##       ##    ##        ##          ##       ##     ## ##  #### ##             ##
+   ##       ##    ##        ##          ##       ##     ## ##   ### ##    ## ##    ##
+   ##       ##    ##        ########    ##        #######  ##    ##  ######   ######
+*/
+
+// Returns true if the port has any changes worth reporting.
+func (pd *portDiff) HasChanges() bool {
+	return !equalVlanIDs(pd.OldUntaggedVlans, pd.NewUntaggedVlans) || !equalVlanIDs(pd.OldTaggedVlans, pd.NewTaggedVlans) || pd.OldOperStatus != pd.NewOperStatus || pd.OldAlias != pd.NewAlias
+}
+
+// Returns true if the device has any changes worth reporting.
+func (dd *deviceDiff) HasChanges() bool {
+	return dd.OldStatus != dd.NewStatus || len(dd.AddedVlans) > 0 || len(dd.RemovedVlans) > 0 || len(dd.AddedPorts) > 0 || len(dd.RemovedPorts) > 0 || len(dd.ChangedPorts) > 0
+}
+
+// Transforms a snapshotDiff struct into rows of values, one row per single change.
+func (sd *snapshotDiff) ToRows() []tableRow {
+	var result []tableRow
+
+	for _, dev := range sd.AddedDevices {
+		result = append(result, tableRow{dev.IPAddress, dev.SysName, "Device", dev.IPAddress, "added", "", "", ""})
+	}
+	for _, dev := range sd.RemovedDevices {
+		result = append(result, tableRow{dev.IPAddress, dev.SysName, "Device", dev.IPAddress, "removed", "", "", ""})
+	}
+	for _, dev := range sd.ChangedDevices {
+		if dev.OldStatus != dev.NewStatus {
+			result = append(result, tableRow{dev.IPAddress, dev.SysName, "Device", dev.IPAddress, "changed", "Status", dev.OldStatus, dev.NewStatus})
+		}
+		for _, vlan := range dev.AddedVlans {
+			result = append(result, tableRow{dev.IPAddress, dev.SysName, "VLAN", vlan.ID, "added", "Name", "", vlan.Name})
+		}
+		for _, vlan := range dev.RemovedVlans {
+			result = append(result, tableRow{dev.IPAddress, dev.SysName, "VLAN", vlan.ID, "removed", "Name", vlan.Name, ""})
+		}
+		for _, port := range dev.AddedPorts {
+			result = append(result, tableRow{dev.IPAddress, dev.SysName, "Port", port, "added", "", "", ""})
+		}
+		for _, port := range dev.RemovedPorts {
+			result = append(result, tableRow{dev.IPAddress, dev.SysName, "Port", port, "removed", "", "", ""})
+		}
+		for _, port := range dev.ChangedPorts {
+			if !equalVlanIDs(port.OldUntaggedVlans, port.NewUntaggedVlans) {
+				result = append(result, tableRow{dev.IPAddress, dev.SysName, "Port", port.Name, "changed", "Untagged", joinVlanIDs(port.OldUntaggedVlans), joinVlanIDs(port.NewUntaggedVlans)})
+			}
+			if !equalVlanIDs(port.OldTaggedVlans, port.NewTaggedVlans) {
+				result = append(result, tableRow{dev.IPAddress, dev.SysName, "Port", port.Name, "changed", "Tagged", joinVlanIDs(port.OldTaggedVlans), joinVlanIDs(port.NewTaggedVlans)})
+			}
+			if port.OldOperStatus != port.NewOperStatus {
+				result = append(result, tableRow{dev.IPAddress, dev.SysName, "Port", port.Name, "changed", "IfStatus", port.OldOperStatus, port.NewOperStatus})
+			}
+			if port.OldAlias != port.NewAlias {
+				result = append(result, tableRow{dev.IPAddress, dev.SysName, "Port", port.Name, "changed", "IfAlias", port.OldAlias, port.NewAlias})
+			}
+		}
+	}
+
+	return result
+}
+
+// Transforms a snapshotDiff struct into a string representing JSON output.
+func (sd *snapshotDiff) ToJSON() (string, error) {
+	json, jsonErr := json.MarshalIndent(sd, "", "    ")
+	if jsonErr != nil {
+		return "", fmt.Errorf("Could not encode JSON: %s", jsonErr)
+	}
+	return string(json), nil
+}
+
+/*
+######## ##     ## ##    ##  ######   ######
+##       ##     ## ###   ## ##    ## ##    ##
+##       ##     ## ####  ## ##       ##
+######   ##     ## ## ## ## ##        ######
+##       ##     ## ##  #### ##             ##
+##       ##     ## ##   ### ##    ## ##    ##
+##        #######  ##    ##  ######   ######
+*/
+
+// Compares the VLANs and ports of the same device in two snapshots
+func compareDevices(oldDevice *singleDevice, newDevice *singleDevice) deviceDiff {
+	result := deviceDiff{IPAddress: newDevice.IPAddress, SysName: newDevice.SysName, OldStatus: oldDevice.Status, NewStatus: newDevice.Status}
+
+	// Without data for both sides, VLANs and ports cannot be compared
+	if !oldDevice.HasData() || !newDevice.HasData() {
+		return result
+	}
+
+	oldVlans := make(map[int]deviceVlan)
+	for _, vlan := range oldDevice.Vlans {
+		oldVlans[vlan.ID] = vlan
+	}
+	newVlans := make(map[int]deviceVlan)
+	for _, vlan := range newDevice.Vlans {
+		newVlans[vlan.ID] = vlan
+		if _, found := oldVlans[vlan.ID]; !found {
+			result.AddedVlans = append(result.AddedVlans, vlan)
+		}
+	}
+	for _, vlan := range oldDevice.Vlans {
+		if _, found := newVlans[vlan.ID]; !found {
+			result.RemovedVlans = append(result.RemovedVlans, vlan)
+		}
+	}
+
+	oldPorts := make(map[string]devicePort)
+	for _, port := range oldDevice.Ports {
+		oldPorts[port.Name] = port
+	}
+	newPorts := make(map[string]devicePort)
+	for _, port := range newDevice.Ports {
+		newPorts[port.Name] = port
+		oldPort, found := oldPorts[port.Name]
+		if !found {
+			result.AddedPorts = append(result.AddedPorts, port.Name)
+			continue
+		}
+		portResult := portDiff{
+			Name:             port.Name,
+			OldUntaggedVlans: oldPort.UntaggedVlans,
+			NewUntaggedVlans: port.UntaggedVlans,
+			OldTaggedVlans:   oldPort.TaggedVlans,
+			NewTaggedVlans:   port.TaggedVlans,
+			OldOperStatus:    oldPort.OperStatus,
+			NewOperStatus:    port.OperStatus,
+			OldAlias:         oldPort.Alias,
+			NewAlias:         port.Alias,
+		}
+		if portResult.HasChanges() {
+			result.ChangedPorts = append(result.ChangedPorts, portResult)
+		}
+	}
+	for _, port := range oldDevice.Ports {
+		if _, found := newPorts[port.Name]; !found {
+			result.RemovedPorts = append(result.RemovedPorts, port.Name)
+		}
+	}
+
+	return result
+}
+
+// Compares two snapshots; devices are identified by their IP address and ports by their name
+func compareSnapshots(oldResults devicesWrapper, newResults devicesWrapper) snapshotDiff {
+	var result snapshotDiff
+
+	oldDevices := make(map[string]*singleDevice)
+	for i := range oldResults.Devices {
+		oldDevices[oldResults.Devices[i].IPAddress] = &oldResults.Devices[i]
+	}
+	newDevices := make(map[string]*singleDevice)
+	for i := range newResults.Devices {
+		newDevice := &newResults.Devices[i]
+		newDevices[newDevice.IPAddress] = newDevice
+		oldDevice, found := oldDevices[newDevice.IPAddress]
+		if !found {
+			result.AddedDevices = append(result.AddedDevices, diffDevice{IPAddress: newDevice.IPAddress, SysName: newDevice.SysName})
+			continue
+		}
+		deviceResult := compareDevices(oldDevice, newDevice)
+		if deviceResult.HasChanges() {
+			result.ChangedDevices = append(result.ChangedDevices, deviceResult)
+		}
+	}
+	for _, oldDevice := range oldResults.Devices {
+		if _, found := newDevices[oldDevice.IPAddress]; !found {
+			result.RemovedDevices = append(result.RemovedDevices, diffDevice{IPAddress: oldDevice.IPAddress, SysName: oldDevice.SysName})
+		}
+	}
+
+	sort.Slice(result.AddedDevices, func(i, j int) bool { return result.AddedDevices[i].IPAddress < result.AddedDevices[j].IPAddress })
+	sort.Slice(result.RemovedDevices, func(i, j int) bool { return result.RemovedDevices[i].IPAddress < result.RemovedDevices[j].IPAddress })
+	sort.Slice(result.ChangedDevices, func(i, j int) bool { return result.ChangedDevices[i].IPAddress < result.ChangedDevices[j].IPAddress })
+
+	return result
+}
+
+// Compares results against the snapshot given by the diff option
+func diffAgainstBaseline(results devicesWrapper) (snapshotDiff, error) {
+	if config.DiffBaseline == "" {
+		return snapshotDiff{}, fmt.Errorf("diff output requires a snapshot to compare against (--diff)")
+	}
+	diffBaselineOnce.Do(func() {
+		diffBaseline, diffBaselineErr = readResultsJSON(config.DiffBaseline)
+	})
+	if diffBaselineErr != nil {
+		return snapshotDiff{}, fmt.Errorf("Could not read snapshot <%s>: %s", config.DiffBaseline, diffBaselineErr)
+	}
+	return compareSnapshots(diffBaseline, results), nil
+}
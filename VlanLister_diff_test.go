@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Returns a device with two VLANs and two ports for diff tests
+func testDiffDevice() singleDevice {
+	return singleDevice{IPAddress: "10.0.0.1", SysName: "core-sw1", Status: deviceStatusOK,
+		Vlans: []deviceVlan{{ID: 1, Name: "Default"}, {ID: 10, Name: "Data"}},
+		Ports: []devicePort{
+			{Name: "1:1", OperStatus: "UP", UntaggedVlans: []int{1}, TaggedVlans: []int{10}},
+			{Name: "1:2", OperStatus: "UP", UntaggedVlans: []int{10}},
+		},
+	}
+}
+
+// Returns testDiffDevice after applying modify to it
+func modifiedDiffDevice(modify func(dev *singleDevice)) singleDevice {
+	dev := testDiffDevice()
+	modify(&dev)
+	return dev
+}
+
+func TestCompareSnapshots(t *testing.T) {
+	accessSwitch := singleDevice{IPAddress: "10.0.0.2", SysName: "access-sw1", Status: deviceStatusOK}
+	changedDevice := func(dd deviceDiff) snapshotDiff {
+		dd.IPAddress, dd.SysName = "10.0.0.1", "core-sw1"
+		if dd.NewStatus == "" {
+			dd.NewStatus = deviceStatusOK
+		}
+		dd.OldStatus = deviceStatusOK
+		return snapshotDiff{ChangedDevices: []deviceDiff{dd}}
+	}
+
+	tests := []struct {
+		name string
+		old  []singleDevice
+		new  []singleDevice
+		want snapshotDiff
+	}{
+		{"no changes", []singleDevice{testDiffDevice()}, []singleDevice{testDiffDevice()}, snapshotDiff{}},
+		{"added device", []singleDevice{testDiffDevice()}, []singleDevice{testDiffDevice(), accessSwitch},
+			snapshotDiff{AddedDevices: []diffDevice{{IPAddress: "10.0.0.2", SysName: "access-sw1"}}}},
+		{"removed device", []singleDevice{accessSwitch, testDiffDevice()}, []singleDevice{testDiffDevice()},
+			snapshotDiff{RemovedDevices: []diffDevice{{IPAddress: "10.0.0.2", SysName: "access-sw1"}}}},
+		{"added port", []singleDevice{testDiffDevice()},
+			[]singleDevice{modifiedDiffDevice(func(dev *singleDevice) { dev.Ports = append(dev.Ports, devicePort{Name: "1:3"}) })},
+			changedDevice(deviceDiff{AddedPorts: []string{"1:3"}})},
+		{"removed port", []singleDevice{testDiffDevice()},
+			[]singleDevice{modifiedDiffDevice(func(dev *singleDevice) { dev.Ports = dev.Ports[:1] })},
+			changedDevice(deviceDiff{RemovedPorts: []string{"1:2"}})},
+		{"VLANs changed on port", []singleDevice{testDiffDevice()},
+			[]singleDevice{modifiedDiffDevice(func(dev *singleDevice) {
+				dev.Ports[0].TaggedVlans = []int{10, 20}
+				dev.Ports[1].UntaggedVlans = []int{1}
+			})},
+			changedDevice(deviceDiff{ChangedPorts: []portDiff{
+				{Name: "1:1", OldUntaggedVlans: []int{1}, NewUntaggedVlans: []int{1}, OldTaggedVlans: []int{10}, NewTaggedVlans: []int{10, 20}, OldOperStatus: "UP", NewOperStatus: "UP"},
+				{Name: "1:2", OldUntaggedVlans: []int{10}, NewUntaggedVlans: []int{1}, OldOperStatus: "UP", NewOperStatus: "UP"},
+			}})},
+		{"added and removed VLANs", []singleDevice{testDiffDevice()},
+			[]singleDevice{modifiedDiffDevice(func(dev *singleDevice) { dev.Vlans = []deviceVlan{{ID: 1, Name: "Default"}, {ID: 20, Name: "Voice"}} })},
+			changedDevice(deviceDiff{AddedVlans: []deviceVlan{{ID: 20, Name: "Voice"}}, RemovedVlans: []deviceVlan{{ID: 10, Name: "Data"}}})},
+		{"device failed", []singleDevice{testDiffDevice()},
+			[]singleDevice{{IPAddress: "10.0.0.1", SysName: "core-sw1", Status: deviceStatusFailed}},
+			changedDevice(deviceDiff{NewStatus: deviceStatusFailed})},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := compareSnapshots(devicesWrapper{Devices: test.old}, devicesWrapper{Devices: test.new})
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("compareSnapshots() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	excelize "github.com/360EntSecGroup-Skylar/excelize/v2"
+	xmcnbiclient "gitlab.com/rbrt-weiler/go-module-xmcnbiclient"
+	mockxmc "gitlab.com/rbrt-weiler/xmc-nbi-vlanlister-go/internal/mockxmc"
+)
+
+// Starts a mock XMC serving the builtin campus fixture and points the app config to it
+func startMockXMC(t *testing.T) *mockxmc.Server {
+	fixture, fixtureErr := mockxmc.BuiltinFixture("campus")
+	if fixtureErr != nil {
+		t.Fatalf("Could not load fixture: %s", fixtureErr)
+	}
+	mockServer := mockxmc.New(fixture)
+	mockServer.ClientID = "client"
+	mockServer.Secret = "secret"
+	server := httptest.NewServer(mockServer.Handler())
+	t.Cleanup(server.Close)
+
+	configureTestXMC(t, server, "")
+	config.RefreshInterval = 0
+	config.RefreshBatch = 2
+	config.RefreshWait = 1
+	config.RefreshPoll = 1
+	config.Workers = 2
+	config.CSVDelimiter = ","
+	summary = runSummary{}
+
+	return mockServer
+}
+
+// Fetches results from the mock XMC like main does
+func fetchTestResults(t *testing.T) devicesWrapper {
+	var client xmcnbiclient.NBIClient
+
+	results, fetchErr := fetchResults(context.Background(), &client)
+	if fetchErr != nil {
+		t.Fatalf("fetchResults() returned error: %s", fetchErr)
+	}
+	return results
+}
+
+// Returns the device with the given IP from the results
+func findTestDevice(t *testing.T, results devicesWrapper, deviceIP string) singleDevice {
+	for _, dev := range results.Devices {
+		if dev.IPAddress == deviceIP {
+			return dev
+		}
+	}
+	t.Fatalf("results do not contain device %s", deviceIP)
+	return singleDevice{}
+}
+
+func TestEndToEndFetchResults(t *testing.T) {
+	mockServer := startMockXMC(t)
+
+	results := fetchTestResults(t)
+	if len(results.Devices) != 4 {
+		t.Fatalf("fetchResults() returned %d device(s), want 4", len(results.Devices))
+	}
+	if results.Partial {
+		t.Errorf("fetchResults() marked results as partial")
+	}
+
+	core := findTestDevice(t, results, "10.0.0.1")
+	if core.Status != deviceStatusOK || core.SysName != "core-sw1" || len(core.Ports) != 5 || len(core.Vlans) != 5 {
+		t.Errorf("core-sw1 has status %q, name %q, %d port(s) and %d VLAN(s), want ok, core-sw1, 5 and 5", core.Status, core.SysName, len(core.Ports), len(core.Vlans))
+	}
+	if !equalVlanIDs(core.Ports[0].TaggedVlans, []int{10, 20, 99}) || !equalVlanIDs(core.Ports[0].UntaggedVlans, []int{1}) {
+		t.Errorf("port 1:1 has untagged %v and tagged %v, want [1] and [10 20 99]", core.Ports[0].UntaggedVlans, core.Ports[0].TaggedVlans)
+	}
+	if uplink := core.Ports[1]; uplink.Alias != "Uplink access-sw2" || uplink.Speed != "10000000000" || uplink.Duplex != "full" || uplink.Type != "ethernetCsmacd" {
+		t.Errorf("port 1:2 has alias %q, speed %q, duplex %q and type %q", uplink.Alias, uplink.Speed, uplink.Duplex, uplink.Type)
+	}
+	if master, member := core.Ports[0], core.Ports[4]; !master.IsLag || !reflect.DeepEqual(master.LagMembers, []string{"1:5"}) || member.Lag != "1:1" {
+		t.Errorf("LAG master 1:1 has members %v, member 1:5 has LAG %q", master.LagMembers, member.Lag)
+	}
+	if mlt := findTestDevice(t, results, "10.0.0.2").Ports[5]; mlt.Name != "Mlt1" || !mlt.IsLag || !reflect.DeepEqual(mlt.LagMembers, []string{"1/1", "1/5"}) {
+		t.Errorf("port %s has members %v, want Mlt1 with members [1/1 1/5]", mlt.Name, mlt.LagMembers)
+	}
+	wantUplinks := map[string]bool{"1:1": true, "1:2": true, "1:3": false, "1:4": false, "1:5": true}
+	for _, port := range core.Ports {
+		if port.Uplink != wantUplinks[port.Name] {
+			t.Errorf("port %s has uplink %t, want %t", port.Name, port.Uplink, wantUplinks[port.Name])
+		}
+	}
+	if neighbors := core.Ports[1].Neighbors; len(neighbors) != 1 || neighbors[0].SysName != "access-sw2" || neighbors[0].Port != "1/1" || neighbors[0].IP != "10.0.0.3" {
+		t.Errorf("port 1:2 has neighbors %+v, want access-sw2 1/1 10.0.0.3", neighbors)
+	}
+	if core.Refresh != refreshFinished {
+		t.Errorf("core-sw1 has refresh %q, want %q", core.Refresh, refreshFinished)
+	}
+	if branch := findTestDevice(t, results, "10.1.0.1"); branch.Status != deviceStatusDown {
+		t.Errorf("branch-sw1 has status %q, want %q", branch.Status, deviceStatusDown)
+	}
+
+	for _, deviceIP := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if rediscovers := mockServer.Rediscovers(deviceIP); rediscovers != 1 {
+			t.Errorf("device %s was rediscovered %d time(s), want 1", deviceIP, rediscovers)
+		}
+	}
+	if mockServer.Rediscovers("10.1.0.1") != 0 {
+		t.Errorf("down device 10.1.0.1 was rediscovered")
+	}
+	if summary.DevicesDiscovered != 4 || summary.DevicesRefreshed != 3 || summary.DevicesQueried != 3 {
+		t.Errorf("summary counts %d discovered, %d refreshed and %d queried device(s), want 4, 3 and 3", summary.DevicesDiscovered, summary.DevicesRefreshed, summary.DevicesQueried)
+	}
+	summary.Finish(results.Devices)
+	if summary.ExitCode != exitOK {
+		t.Errorf("summary has exit code %d, want %d", summary.ExitCode, exitOK)
+	}
+}
+
+func TestEndToEndFailedDevice(t *testing.T) {
+	mockServer := startMockXMC(t)
+	mockServer.FailDevice("10.0.0.2", http.StatusInternalServerError)
+	config.NoRefresh = true
+
+	results := fetchTestResults(t)
+	if failed := findTestDevice(t, results, "10.0.0.2"); failed.Status != deviceStatusFailed || failed.Error == "" {
+		t.Errorf("access-sw1 has status %q and error %q, want %q and an error", failed.Status, failed.Error, deviceStatusFailed)
+	}
+	if ok := findTestDevice(t, results, "10.0.0.3"); ok.Status != deviceStatusOK {
+		t.Errorf("access-sw2 has status %q, want %q", ok.Status, deviceStatusOK)
+	}
+	summary.Finish(results.Devices)
+	if summary.ExitCode != exitPartialFailure {
+		t.Errorf("summary has exit code %d, want %d", summary.ExitCode, exitPartialFailure)
+	}
+}
+
+func TestEndToEndFilters(t *testing.T) {
+	startMockXMC(t)
+	config.NoRefresh = true
+	var filterErr error
+	deviceIncludes, filterErr = parseDeviceFilters([]string{"family=VSP*"})
+	if filterErr != nil {
+		t.Fatalf("parseDeviceFilters() returned error: %s", filterErr)
+	}
+	defer func() { deviceIncludes = nil }()
+
+	results := fetchTestResults(t)
+	if len(results.Devices) != 1 || results.Devices[0].IPAddress != "10.0.0.2" {
+		t.Errorf("fetchResults() returned %d device(s), want only 10.0.0.2", len(results.Devices))
+	}
+}
+
+func TestEndToEndWriters(t *testing.T) {
+	startMockXMC(t)
+	config.NoRefresh = true
+	results := fetchTestResults(t)
+	outDir := t.TempDir()
+
+	// diff outfiles compare against a snapshot written by the json writer
+	config.DiffBaseline = filepath.Join(outDir, "baseline.json")
+	if _, writeErr := writeResults("json:"+config.DiffBaseline, results); writeErr != nil {
+		t.Fatalf("Could not write baseline: %s", writeErr)
+	}
+	diffBaselineOnce = sync.Once{}
+	results.Devices[1].Ports = results.Devices[1].Ports[1:]
+
+	for _, filetype := range validFiletypes {
+		filename := filepath.Join(outDir, "out."+filetype)
+		if filetype == "stdout" {
+			stdout := os.Stdout
+			os.Stdout, _ = os.Create(filename)
+			rows, writeErr := writeResults("stdout:", results)
+			os.Stdout.Close()
+			os.Stdout = stdout
+			checkTestOutfile(t, filetype, filename, rows, writeErr)
+			continue
+		}
+		rows, writeErr := writeResults(filetype+":"+filename, results)
+		checkTestOutfile(t, filetype, filename, rows, writeErr)
+	}
+
+	if _, writeErr := writeResults("csv:"+filepath.Join(outDir, "compressed.csv.gz"), results); writeErr != nil {
+		t.Errorf("writeResults() returned error for compressed CSV: %s", writeErr)
+	}
+	if _, statErr := os.Stat(filepath.Join(outDir, "compressed.csv.gz")); statErr != nil {
+		t.Errorf("compressed CSV was not written: %s", statErr)
+	}
+}
+
+// Checks that an outfile was written and can be parsed according to its type
+func checkTestOutfile(t *testing.T, filetype string, filename string, rows uint, writeErr error) {
+	if writeErr != nil {
+		t.Errorf("%s: writeResults() returned error: %s", filetype, writeErr)
+		return
+	}
+	if rows == 0 {
+		t.Errorf("%s: writeResults() wrote no rows", filetype)
+	}
+
+	switch filetype {
+	case "csv", "stdout", "vlans-csv":
+		fileHandle, openErr := os.Open(filename)
+		if openErr != nil {
+			t.Fatalf("%s: could not open outfile: %s", filetype, openErr)
+		}
+		defer fileHandle.Close()
+		records, csvErr := csv.NewReader(fileHandle).ReadAll()
+		if csvErr != nil || len(records) < 2 {
+			t.Errorf("%s: outfile contains %d record(s) and error %v", filetype, len(records), csvErr)
+		}
+	case "json", "vlans-json", "diff-json", "audit-json", "names-json":
+		var data map[string]interface{}
+		content, _ := ioutil.ReadFile(filename)
+		if jsonErr := json.Unmarshal(content, &data); jsonErr != nil {
+			t.Errorf("%s: outfile is not valid JSON: %s", filetype, jsonErr)
+		}
+	case "xlsx", "diff-xlsx", "audit-xlsx", "names-xlsx":
+		xlsx, xlsxErr := excelize.OpenFile(filename)
+		if xlsxErr != nil {
+			t.Errorf("%s: outfile is not a valid XLSX file: %s", filetype, xlsxErr)
+			return
+		}
+		if filetype == "xlsx" && len(xlsx.GetSheetList()) != 7 {
+			t.Errorf("%s: outfile contains sheets %v, want 7 sheets", filetype, xlsx.GetSheetList())
+		}
+	case "diff-txt", "audit-txt", "names-txt":
+		content, _ := ioutil.ReadFile(filename)
+		if len(content) == 0 {
+			t.Errorf("%s: outfile is empty", filetype)
+		}
+	default:
+		t.Errorf("%s: no check for file type", filetype)
+	}
+}
+
+func TestEndToEndRefreshFailure(t *testing.T) {
+	mockServer := startMockXMC(t)
+	mockServer.RejectRediscover("10.0.0.3", true)
+
+	results := fetchTestResults(t)
+	if len(results.Devices) != 4 {
+		t.Fatalf("fetchResults() returned %d device(s), want 4", len(results.Devices))
+	}
+	if failed := findTestDevice(t, results, "10.0.0.3"); failed.Status != deviceStatusFailed || failed.Error == "" {
+		t.Errorf("access-sw2 has status %q and error %q, want %q and an error", failed.Status, failed.Error, deviceStatusFailed)
+	}
+	if summary.DevicesRefreshed != 2 || summary.DevicesQueried != 2 {
+		t.Errorf("summary counts %d refreshed and %d queried device(s), want 2 and 2", summary.DevicesRefreshed, summary.DevicesQueried)
+	}
+	summary.Finish(results.Devices)
+	if summary.DevicesFailed != 1 || summary.ExitCode != exitPartialFailure {
+		t.Errorf("summary counts %d failed device(s) with exit code %d, want 1 and %d", summary.DevicesFailed, summary.ExitCode, exitPartialFailure)
+	}
+}
+
+func TestEndToEndRefreshInterrupted(t *testing.T) {
+	var client xmcnbiclient.NBIClient
+
+	mockServer := startMockXMC(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Interrupt the run as soon as the first batch of devices has been refreshed
+	mockServer.Logf = func(format string, args ...interface{}) {
+		if strings.HasPrefix(format, "Rediscover of") {
+			cancel()
+		}
+	}
+
+	results, fetchErr := fetchResults(ctx, &client)
+	if fetchErr != nil {
+		t.Fatalf("fetchResults() returned error: %s", fetchErr)
+	}
+	if len(results.Devices) != 4 || !results.Partial {
+		t.Fatalf("fetchResults() returned %d device(s) with partial %t, want 4 and true", len(results.Devices), results.Partial)
+	}
+	for _, deviceIP := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if dev := findTestDevice(t, results, deviceIP); dev.Status != deviceStatusSkipped {
+			t.Errorf("device %s has status %q, want %q", deviceIP, dev.Status, deviceStatusSkipped)
+		}
+	}
+	if skipped := findTestDevice(t, results, "10.0.0.3"); !strings.Contains(skipped.Error, "refreshed") || mockServer.Rediscovers("10.0.0.3") != 0 {
+		t.Errorf("access-sw2 has error %q and was rediscovered %d time(s), want a refresh interruption", skipped.Error, mockServer.Rediscovers("10.0.0.3"))
+	}
+}
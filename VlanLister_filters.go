@@ -0,0 +1,256 @@
+package main
+
+/*
+#### ##     ## ########   #######  ########  ########  ######
+ ##  ###   ### ##     ## ##     ## ##     ##    ##    ##    ##
+ ##  #### #### ##     ## ##     ## ##     ##    ##    ##
+ ##  ## ### ## ########  ##     ## ########     ##     ######
+ ##  ##     ## ##        ##     ## ##   ##      ##          ##
+ ##  ##     ## ##        ##     ## ##    ##     ##    ##    ##
+#### ##     ## ##         #######  ##     ##    ##     ######
+*/
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+)
+
+/*
+ ######   #######  ##    ##  ######  ########    ###    ##    ## ########  ######
+##    ## ##     ## ###   ## ##    ##    ##      ## ##   ###   ##    ##    ##    ##
+##       ##     ## ####  ## ##          ##     ##   ##  ####  ##    ##    ##
+##       ##     ## ## ## ##  ######     ##    ##     ## ## ## ##    ##     ######
+##       ##     ## ##  ####       ##    ##    ######### ##  ####    ##          ##
+##    ## ##     ## ##   ### ##    ##    ##    ##     ## ##   ###    ##    ##    ##
+ ######   #######  ##    ##  ######     ##    ##     ## ##    ##    ##     ######
+*/
+
+const (
+	// Prefix that marks a filter pattern as regular expression instead of glob
+	filterRegexPrefix string = "re:"
+)
+
+/*
+##     ##    ###    ########   ######
+##     ##   ## ##   ##     ## ##    ##
+##     ##  ##   ##  ##     ## ##
+##     ## ##     ## ########   ######
+ ##   ##  ######### ##   ##         ##
+  ## ##   ##     ## ##    ##  ##    ##
+   ###    ##     ## ##     ##  ######
+*/
+
+var (
+	// Device attributes that can be used in filters
+	validFilterFields = [...]string{"ip", "sysname", "location", "family", "type", "site"}
+	// Filters that devices must match to be included
+	deviceIncludes []deviceFilter
+	// Filters that devices must not match to be included
+	deviceExcludes []deviceFilter
+	// IPs of the devices that shall be processed exclusively; nil if all devices shall be processed
+	deviceList map[string]bool
+)
+
+/*
+######## ##    ## ########  ########  ######
+   ##     ##  ##  ##     ## ##       ##    ##
+   ##      ####   ##     ## ##       ##
+   ##       ##    ########  ######    ######
+   ##       ##    ##        ##             ##
+   ##       ##    ##        ##       ##    ##
+   ##       ##    ##        ########  ######
+*/
+
+// Stores the attributes of a managed device that filters are applied to.
+type managedDevice struct {
+	IP          string
+	Up          bool
+	SysName     string
+	SysLocation string
+	Family      string
+	Type        string
+	Site        string
+}
+
+// Stores a single filter rule in the form FIELD=PATTERN.
+type deviceFilter struct {
+	Field   string
+	Pattern string
+	network *net.IPNet
+	regex   *regexp.Regexp
+}
+
+/*
+######## ##    ## ########  ########    ######## ##     ## ##    ##  ######   ######
+   ##     ##  ##  ##     ## ##          ##       ##     ## ###   ## ##    ## ##    ##
+   ##      ####   ##     ## ##          ##       ##     ## ####  ## ##       ##
+   ##       ##    ########  ######      ######   ##     ## ## ## ## ##        ######
+   ##       ##    ##        ##          ##       ##     ## ##  #### ##             ##
+   ##       ##    ##        ##          ##       ##     ## ##   ### ##    ## ##    ##
+   ##       ##    ##        ########    ##        #######  ##    ##  ######   ######
+*/
+
+// Returns the value of the attribute that is selected by field.
+func (md *managedDevice) Attribute(field string) string {
+	switch field {
+	case "ip":
+		return md.IP
+	case "sysname":
+		return md.SysName
+	case "location":
+		return md.SysLocation
+	case "family":
+		return md.Family
+	case "type":
+		return md.Type
+	case "site":
+		return md.Site
+	}
+	return ""
+}
+
+// Returns a representation of the filter as given on the CLI.
+func (df *deviceFilter) String() string {
+	return fmt.Sprintf("%s=%s", df.Field, df.Pattern)
+}
+
+// Returns true if the device matches the filter.
+func (df *deviceFilter) Matches(md *managedDevice) bool {
+	if df.network != nil {
+		deviceIP := net.ParseIP(md.IP)
+		return deviceIP != nil && df.network.Contains(deviceIP)
+	}
+	return df.regex.MatchString(md.Attribute(df.Field))
+}
+
+/*
+######## ##     ## ##    ##  ######   ######
+##       ##     ## ###   ## ##    ## ##    ##
+##       ##     ## ####  ## ##       ##
+######   ##     ## ## ## ## ##        ######
+##       ##     ## ##  #### ##             ##
+##       ##     ## ##   ### ##    ## ##    ##
+##        #######  ##    ##  ######   ######
+*/
+
+// Transforms a glob pattern (* and ?) into a case-insensitive regular expression
+func globToRegex(pattern string) string {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.ReplaceAll(quoted, `\*`, `.*`)
+	quoted = strings.ReplaceAll(quoted, `\?`, `.`)
+	return fmt.Sprintf("(?i)^%s$", quoted)
+}
+
+// Parses a single filter rule in the form FIELD=PATTERN
+func parseDeviceFilter(rule string) (deviceFilter, error) {
+	var filter deviceFilter
+
+	ruleParts := strings.SplitN(rule, "=", 2)
+	if len(ruleParts) != 2 || ruleParts[1] == "" {
+		return filter, fmt.Errorf("filter <%s> is not in the form FIELD=PATTERN", rule)
+	}
+	filter.Field = strings.ToLower(strings.TrimSpace(ruleParts[0]))
+	filter.Pattern = ruleParts[1]
+
+	validField := false
+	for _, field := range validFilterFields {
+		if filter.Field == field {
+			validField = true
+		}
+	}
+	if !validField {
+		return filter, fmt.Errorf("filter <%s> uses unknown field <%s>", rule, filter.Field)
+	}
+
+	if filter.Field == "ip" && !strings.HasPrefix(filter.Pattern, filterRegexPrefix) && !strings.ContainsAny(filter.Pattern, "*?") {
+		network := filter.Pattern
+		if !strings.Contains(network, "/") {
+			if ip := net.ParseIP(network); ip != nil && ip.To4() != nil {
+				network = fmt.Sprintf("%s/32", network)
+			} else {
+				network = fmt.Sprintf("%s/128", network)
+			}
+		}
+		_, ipNet, netErr := net.ParseCIDR(network)
+		if netErr != nil {
+			return filter, fmt.Errorf("filter <%s> contains no valid IP address or CIDR", rule)
+		}
+		filter.network = ipNet
+		return filter, nil
+	}
+
+	var regexErr error
+	if strings.HasPrefix(filter.Pattern, filterRegexPrefix) {
+		filter.regex, regexErr = regexp.Compile(strings.TrimPrefix(filter.Pattern, filterRegexPrefix))
+	} else {
+		filter.regex, regexErr = regexp.Compile(globToRegex(filter.Pattern))
+	}
+	if regexErr != nil {
+		return filter, fmt.Errorf("filter <%s> contains an invalid pattern: %s", rule, regexErr)
+	}
+
+	return filter, nil
+}
+
+// Returns the filter rules stored in an environment variable, separated by semicolons
+func envFilterRules(name string) stringArray {
+	var rules stringArray
+	for _, rule := range strings.Split(os.Getenv(name), ";") {
+		if rule = strings.TrimSpace(rule); rule != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// Parses a list of filter rules
+func parseDeviceFilters(rules []string) ([]deviceFilter, error) {
+	var filters []deviceFilter
+	for _, rule := range rules {
+		filter, filterErr := parseDeviceFilter(rule)
+		if filterErr != nil {
+			return nil, filterErr
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// Returns the canonical representation of an IP address, e.g. for comparing IPv6 addresses
+func canonicalIP(ip string) string {
+	if parsedIP := net.ParseIP(ip); parsedIP != nil {
+		return parsedIP.String()
+	}
+	return ip
+}
+
+// Decides whether a device shall be processed based on the include and exclude filters.
+// A device must match at least one include filter per field that has include filters
+// and must not match any exclude filter.
+func deviceSelected(md *managedDevice) bool {
+	includeFields := make(map[string]bool)
+	for _, filter := range deviceIncludes {
+		if _, found := includeFields[filter.Field]; !found {
+			includeFields[filter.Field] = false
+		}
+		if filter.Matches(md) {
+			includeFields[filter.Field] = true
+		}
+	}
+	for _, matched := range includeFields {
+		if !matched {
+			return false
+		}
+	}
+
+	for _, filter := range deviceExcludes {
+		if filter.Matches(md) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// Returns a managed device with all filter fields set
+func testManagedDevice() managedDevice {
+	return managedDevice{IP: "10.1.2.3", Up: true, SysName: "core-sw1.example.com", SysLocation: "Building A, Room 101",
+		Family: "VSP Series", Type: "VSP-7254XSQ", Site: "/World/Campus"}
+}
+
+func TestParseDeviceFilter(t *testing.T) {
+	device := testManagedDevice()
+	tests := []struct {
+		rule  string
+		match bool
+	}{
+		{"ip=10.1.2.3", true},
+		{"ip=10.1.2.4", false},
+		{"ip=10.1.0.0/16", true},
+		{"ip=10.2.0.0/16", false},
+		{"ip=10.1.2.*", true},
+		{"ip=re:^10\\.1\\.", true},
+		{"sysname=core-*", true},
+		{"SysName=CORE-SW?.example.com", true},
+		{"sysname=core-sw", false},
+		{"sysname=re:^core-sw[0-9]+\\.", true},
+		{"sysname=re:^access-", false},
+		{"location=*Room 101", true},
+		{"location=Building B*", false},
+		{"family=vsp series", true},
+		{"family=re:^ERS", false},
+		{"type=VSP-7254*", true},
+		{"type=re:XSQ$", true},
+		{"site=/World/*", true},
+		{"site=/World", false},
+	}
+	for _, test := range tests {
+		filter, filterErr := parseDeviceFilter(test.rule)
+		if filterErr != nil {
+			t.Errorf("parseDeviceFilter(%q) returned error: %s", test.rule, filterErr)
+			continue
+		}
+		if got := filter.Matches(&device); got != test.match {
+			t.Errorf("filter %q matches %t, want %t", test.rule, got, test.match)
+		}
+	}
+}
+
+func TestParseDeviceFilterInvalid(t *testing.T) {
+	for _, rule := range []string{
+		"sysname",
+		"sysname=",
+		"hostname=core-sw1",
+		"ip=10.1.2.0/33",
+		"ip=not-an-ip",
+		"sysname=re:core-(sw",
+		"type=re:[",
+	} {
+		if _, filterErr := parseDeviceFilter(rule); filterErr == nil {
+			t.Errorf("parseDeviceFilter(%q) returned no error", rule)
+		}
+	}
+}
+
+func TestDeviceSelected(t *testing.T) {
+	device := testManagedDevice()
+	tests := []struct {
+		name     string
+		includes []string
+		excludes []string
+		want     bool
+	}{
+		{"no filters", nil, nil, true},
+		{"matching include", []string{"family=VSP*"}, nil, true},
+		{"one include per field", []string{"family=ERS*", "family=VSP*"}, nil, true},
+		{"include of each field", []string{"family=VSP*", "ip=10.2.0.0/16"}, nil, false},
+		{"non-matching include", []string{"sysname=access-*"}, nil, false},
+		{"matching exclude", nil, []string{"site=/World/Campus"}, false},
+		{"non-matching exclude", nil, []string{"site=/World/Branch*"}, true},
+		{"exclude overrides include", []string{"ip=10.1.0.0/16"}, []string{"sysname=core-*"}, false},
+	}
+	t.Cleanup(func() {
+		deviceIncludes, deviceExcludes = nil, nil
+	})
+	for _, test := range tests {
+		var includeErr, excludeErr error
+		deviceIncludes, includeErr = parseDeviceFilters(test.includes)
+		deviceExcludes, excludeErr = parseDeviceFilters(test.excludes)
+		if includeErr != nil || excludeErr != nil {
+			t.Fatalf("%s: parseDeviceFilters() returned errors %v and %v", test.name, includeErr, excludeErr)
+		}
+		if got := deviceSelected(&device); got != test.want {
+			t.Errorf("%s: deviceSelected() = %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestEnvFilterRules(t *testing.T) {
+	os.Setenv("XMCINCLUDE", "family=VSP*; location=Building A, Room 101 ;;")
+	t.Cleanup(func() { os.Unsetenv("XMCINCLUDE") })
+	want := stringArray{"family=VSP*", "location=Building A, Room 101"}
+	if got := envFilterRules("XMCINCLUDE"); !reflect.DeepEqual(got, want) {
+		t.Errorf("envFilterRules() = %q, want %q", got, want)
+	}
+	if got := envFilterRules("XMCEXCLUDE_UNSET"); got != nil {
+		t.Errorf("envFilterRules() = %q for an unset variable, want nil", got)
+	}
+}
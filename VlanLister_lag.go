@@ -0,0 +1,150 @@
+package main
+
+/*
+#### ##     ## ########   #######  ########  ########  ######
+ ##  ###   ### ##     ## ##     ## ##     ##    ##    ##    ##
+ ##  #### #### ##     ## ##     ## ##     ##    ##    ##
+ ##  ## ### ## ########  ##     ## ########     ##     ######
+ ##  ##     ## ##        ##     ## ##   ##      ##          ##
+ ##  ##     ## ##        ##     ## ##    ##     ##    ##    ##
+#### ##     ## ##         #######  ##     ##    ##     ######
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+ ######   #######  ##    ##  ######  ########    ###    ##    ## ########  ######
+##    ## ##     ## ###   ## ##    ##    ##      ## ##   ###   ##    ##    ##    ##
+##       ##     ## ####  ## ##          ##     ##   ##  ####  ##    ##    ##
+##       ##     ## ## ## ##  ######     ##    ##     ## ## ## ##    ##     ######
+##       ##     ## ##  ####       ##    ##    ######### ##  ####    ##          ##
+##    ## ##     ## ##   ### ##    ##    ##    ##     ## ##   ###    ##    ##    ##
+ ######   #######  ##    ##  ######     ##    ##     ## ##    ##    ##     ######
+*/
+
+const (
+	// Member ports are listed individually along with the name of their LAG
+	lagModeAnnotate string = "annotate"
+	// Member ports are left out and listed as LagMembers of their LAG
+	lagModeCollapse string = "collapse"
+)
+
+/*
+##     ##    ###    ########   ######
+##     ##   ## ##   ##     ## ##    ##
+##     ##  ##   ##  ##     ## ##
+##     ## ##     ## ########   ######
+ ##   ##  ######### ##   ##         ##
+  ## ##   ##     ## ##    ##  ##    ##
+   ###    ##     ## ##     ##  ######
+*/
+
+var (
+	// Valid values for lag-mode
+	validLagModes = [...]string{lagModeAnnotate, lagModeCollapse}
+	// Interface types that identify a logical LAG interface, by name and IANA number
+	lagIfTypes = [...]string{"ieee8023adlag", "161"}
+)
+
+/*
+######## ##     ## ##    ##  ######   ######
+##       ##     ## ###   ## ##    ## ##    ##
+##       ##     ## ####  ## ##       ##
+######   ##     ## ## ## ## ##        ######
+##       ##     ## ##  #### ##             ##
+##       ##     ## ##   ### ##    ## ##    ##
+##        #######  ##    ##  ######   ######
+*/
+
+// Checks whether a LAG mode is valid
+func validLagMode(mode string) bool {
+	for _, validMode := range validLagModes {
+		if mode == validMode {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether an interface type identifies a logical LAG interface
+func isLagIfType(ifType string) bool {
+	for _, lagIfType := range lagIfTypes {
+		if strings.EqualFold(ifType, lagIfType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Links the member ports of each LAG to the port representing the LAG.
+// Depending on the firmware, that port is a logical LAG interface (VOSS MLT, Cisco Port-channel)
+// or the master port of the LAG (EXOS sharing).
+func linkLagMembers(ports []devicePort) {
+	portsByIndex := make(map[int]*devicePort)
+	for i := range ports {
+		portsByIndex[ports[i].Index] = &ports[i]
+		ports[i].IsLag = isLagIfType(ports[i].Type)
+	}
+
+	for i := range ports {
+		member := &ports[i]
+		if member.LagIfIndex == 0 || member.LagIfIndex == member.Index {
+			continue
+		}
+		lag, found := portsByIndex[member.LagIfIndex]
+		if !found {
+			member.Lag = fmt.Sprintf("ifIndex %d", member.LagIfIndex)
+			continue
+		}
+		lag.IsLag = true
+		lag.LagMembers = append(lag.LagMembers, member.Name)
+		member.Lag = lag.Name
+	}
+}
+
+// Returns a copy of the results in which the member ports of each LAG are left out.
+// VLANs and neighbors that are only known for members are added to the port representing the LAG.
+func collapseLags(results devicesWrapper) devicesWrapper {
+	collapsed := devicesWrapper{Partial: results.Partial}
+
+	for _, dev := range results.Devices {
+		knownPorts := make(map[int]bool)
+		for _, port := range dev.Ports {
+			knownPorts[port.Index] = true
+		}
+		isCollapsed := func(port devicePort) bool {
+			return port.LagIfIndex != 0 && port.LagIfIndex != port.Index && knownPorts[port.LagIfIndex]
+		}
+
+		var ports []devicePort
+		portPositions := make(map[int]int)
+		for _, port := range dev.Ports {
+			if isCollapsed(port) {
+				continue
+			}
+			portPositions[port.Index] = len(ports)
+			ports = append(ports, port)
+		}
+		for _, port := range dev.Ports {
+			if !isCollapsed(port) {
+				continue
+			}
+			lagPosition, found := portPositions[port.LagIfIndex]
+			if !found {
+				continue
+			}
+			lag := &ports[lagPosition]
+			lag.UntaggedVlans = uniqueVlanIDs(append(append([]int(nil), lag.UntaggedVlans...), port.UntaggedVlans...))
+			lag.TaggedVlans = uniqueVlanIDs(append(append([]int(nil), lag.TaggedVlans...), port.TaggedVlans...))
+			lag.Neighbors = append(append([]portNeighbor(nil), lag.Neighbors...), port.Neighbors...)
+			lag.Uplink = lag.Uplink || port.Uplink
+		}
+		dev.Ports = ports
+		collapsed.Devices = append(collapsed.Devices, dev)
+	}
+
+	return collapsed
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Returns the ports of a switch with an MLT interface and an EXOS-style LAG led by a master port
+func testLagPorts() []devicePort {
+	return []devicePort{
+		{Index: 1, Name: "1/1", LagIfIndex: 6144, TaggedVlans: []int{10, 20}, Uplink: true, Neighbors: []portNeighbor{{SysName: "core-sw1", Port: "1:1"}}},
+		{Index: 2, Name: "1/2", LagIfIndex: 6144, TaggedVlans: []int{10, 20, 30}},
+		{Index: 3, Name: "1/3", UntaggedVlans: []int{10}},
+		{Index: 4, Name: "1/4", LagIfIndex: 4, TaggedVlans: []int{40}},
+		{Index: 5, Name: "1/5", LagIfIndex: 4, TaggedVlans: []int{40}},
+		{Index: 6, Name: "1/6", LagIfIndex: 9999, TaggedVlans: []int{50}},
+		{Index: 6144, Name: "Mlt1", Type: "ieee8023adLag", TaggedVlans: []int{10, 20}},
+	}
+}
+
+func TestLinkLagMembers(t *testing.T) {
+	ports := testLagPorts()
+	linkLagMembers(ports)
+
+	wantLags := []string{"Mlt1", "Mlt1", "", "", "1/4", "ifIndex 9999", ""}
+	wantMembers := [][]string{nil, nil, nil, {"1/5"}, nil, nil, {"1/1", "1/2"}}
+	for i, port := range ports {
+		if port.Lag != wantLags[i] {
+			t.Errorf("port %s has LAG %q, want %q", port.Name, port.Lag, wantLags[i])
+		}
+		if !reflect.DeepEqual(port.LagMembers, wantMembers[i]) {
+			t.Errorf("port %s has LAG members %v, want %v", port.Name, port.LagMembers, wantMembers[i])
+		}
+		if wantIsLag := wantMembers[i] != nil; port.IsLag != wantIsLag {
+			t.Errorf("port %s has IsLag %t, want %t", port.Name, port.IsLag, wantIsLag)
+		}
+	}
+}
+
+func TestCollapseLags(t *testing.T) {
+	ports := testLagPorts()
+	linkLagMembers(ports)
+	results := devicesWrapper{Devices: []singleDevice{{IPAddress: "192.0.2.1", Ports: ports}}}
+
+	collapsed := collapseLags(results)
+	var names []string
+	for _, port := range collapsed.Devices[0].Ports {
+		names = append(names, port.Name)
+	}
+	if want := []string{"1/3", "1/4", "1/6", "Mlt1"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("collapseLags() kept ports %v, want %v", names, want)
+	}
+	if mlt := collapsed.Devices[0].Ports[3]; !reflect.DeepEqual(mlt.TaggedVlans, []int{10, 20, 30}) {
+		t.Errorf("Mlt1 has tagged VLANs %v after collapsing, want [10 20 30]", mlt.TaggedVlans)
+	}
+	if mlt := collapsed.Devices[0].Ports[3]; !mlt.Uplink || len(mlt.Neighbors) != 1 {
+		t.Errorf("Mlt1 has uplink %t and neighbors %v after collapsing, want the neighbor of 1/1", mlt.Uplink, mlt.Neighbors)
+	}
+	if len(results.Devices[0].Ports) != 7 || !reflect.DeepEqual(results.Devices[0].Ports[6].TaggedVlans, []int{10, 20}) {
+		t.Errorf("collapseLags() modified the original results")
+	}
+}
@@ -11,16 +11,23 @@ package main
 */
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
 
 	godotenv "github.com/joho/godotenv"
 	pflag "github.com/spf13/pflag"
 	envordef "gitlab.com/rbrt-weiler/go-module-envordef"
 	xmcnbiclient "gitlab.com/rbrt-weiler/go-module-xmcnbiclient"
+	mockxmc "gitlab.com/rbrt-weiler/xmc-nbi-vlanlister-go/internal/mockxmc"
 )
 
 /*
@@ -76,18 +83,41 @@ func parseCLIOptions() {
 	pflag.UintVar(&config.XMCPort, "port", envordef.UintVal("XMCPORT", 8443), "HTTP port where XMC is listening")
 	pflag.StringVar(&config.XMCPath, "path", envordef.StringVal("XMCPATH", ""), "Path where XMC is reachable")
 	pflag.UintVar(&config.HTTPTimeout, "timeout", envordef.UintVal("XMCTIMEOUT", 5), "Timeout for HTTP(S) connections")
+	pflag.UintVar(&config.Retries, "retries", envordef.UintVal("XMCRETRIES", 3), "Number of retries for failed requests to XMC")
+	pflag.UintVar(&config.RetryDelay, "retrydelay", envordef.UintVal("XMCRETRYDELAY", 1000), "Milliseconds to wait before the first retry; doubled for each further retry")
 	pflag.BoolVar(&config.NoHTTPS, "nohttps", envordef.BoolVal("XMCNOHTTPS", false), "Use HTTP instead of HTTPS")
 	pflag.BoolVar(&config.InsecureHTTPS, "insecurehttps", envordef.BoolVal("XMCINSECUREHTTPS", false), "Do not validate HTTPS certificates")
 	pflag.StringVarP(&config.XMCUserID, "userid", "u", envordef.StringVal("XMCUSERID", ""), "Client ID (OAuth) or username (Basic Auth) for authentication")
 	pflag.StringVarP(&config.XMCSecret, "secret", "s", envordef.StringVal("XMCSECRET", ""), "Client Secret (OAuth) or password (Basic Auth) for authentication")
 	pflag.BoolVar(&config.BasicAuth, "basicauth", envordef.BoolVal("XMCBASICAUTH", false), "Use HTTP Basic Auth instead of OAuth")
 	pflag.BoolVar(&config.NoRefresh, "norefresh", envordef.BoolVal("XMCNOREFRESH", false), "Do not refresh (rediscover) devices")
+	pflag.BoolVar(&config.NoNeighbors, "noneighbors", envordef.BoolVal("XMCNONEIGHBORS", false), "Do not fetch LLDP/CDP neighbors of ports")
 	pflag.UintVar(&config.RefreshInterval, "refreshinterval", envordef.UintVal("XMCREFRESHINTERVAL", 5), "Seconds to wait between triggering each refresh")
-	pflag.UintVar(&config.RefreshWait, "refreshwait", envordef.UintVal("XMCREFRESHWAIT", 15), "Minutes to wait after refreshing devices")
+	pflag.UintVar(&config.RefreshBatch, "refreshbatch", envordef.UintVal("XMCREFRESHBATCH", 1), "Number of devices to refresh with a single request")
+	pflag.UintVar(&config.RefreshWait, "refreshwait", envordef.UintVal("XMCREFRESHWAIT", 15), "Maximum minutes to wait for refreshing devices to finish")
+	pflag.UintVar(&config.RefreshPoll, "refreshpoll", envordef.UintVal("XMCREFRESHPOLL", 30), "Seconds to wait between checking whether refreshing has finished")
+	pflag.UintVar(&config.Workers, "workers", envordef.UintVal("XMCWORKERS", 4), "Number of devices to query concurrently")
 	pflag.BoolVar(&config.IncludeDown, "includedown", envordef.BoolVal("XMCINCLUDEDOWN", false), "Include inactive devices in result")
+	pflag.StringVar(&config.DevicesFile, "devices-file", envordef.StringVal("XMCDEVICESFILE", ""), "File with device IPs to process instead of all devices (\"-\" for stdin)")
+	pflag.Var(&config.Include, "include", "Only process devices matching FIELD=PATTERN")
+	pflag.Var(&config.Exclude, "exclude", "Do not process devices matching FIELD=PATTERN")
 	pflag.BoolVar(&config.NoColor, "nocolor", envordef.BoolVal("XMCNOCOLOR", false), "Do not colorize output (Excel)")
 	pflag.BoolVar(&config.CompressOutput, "compress-output", envordef.BoolVal("XMCCOMPRESSOUTPUT", false), "Compress output using gzip")
+	pflag.StringVar(&config.Infile, "infile", envordef.StringVal("XMCINFILE", ""), "JSON file to read data from instead of querying XMC")
+	pflag.StringVar(&config.DiffBaseline, "diff", envordef.StringVal("XMCDIFF", ""), "JSON file to compare the results against for diff outfiles")
+	pflag.StringVar(&config.VlanCatalog, "vlan-catalog", envordef.StringVal("XMCVLANCATALOG", ""), "YAML or CSV file with reference VLAN names for names outfiles")
+	pflag.StringVar(&config.Checkpoint, "checkpoint", envordef.StringVal("XMCCHECKPOINT", ""), "File to record each queried device in for resuming")
+	pflag.BoolVar(&config.Resume, "resume", envordef.BoolVal("XMCRESUME", false), "Reuse devices from checkpoint instead of refreshing and querying them again")
+	pflag.StringVar(&config.Record, "record", envordef.StringVal("XMCRECORD", ""), "Directory to record all queries and responses in")
+	pflag.StringVar(&config.Replay, "replay", envordef.StringVal("XMCREPLAY", ""), "Directory to replay recorded responses from instead of querying XMC")
+	pflag.StringVar(&config.SummaryFile, "summary-file", envordef.StringVal("XMCSUMMARYFILE", ""), "File to write the run summary to as JSON instead of stderr")
 	pflag.Var(&config.Outfile, "outfile", "File to write data to")
+	pflag.StringVar(&config.CSVDelimiter, "csv-delimiter", envordef.StringVal("XMCCSVDELIMITER", ","), "Character that separates fields in CSV output (use \"tab\" for tabs)")
+	pflag.BoolVar(&config.CSVBOM, "csv-bom", envordef.BoolVal("XMCCSVBOM", false), "Start CSV output with a UTF-8 byte order mark")
+	pflag.StringVar(&config.LagMode, "lag-mode", envordef.StringVal("XMCLAGMODE", lagModeAnnotate), "How to output LAG member ports (annotate or collapse)")
+	pflag.StringVar(&config.Columns, "columns", envordef.StringVal("XMCCOLUMNS", ""), "Comma-separated list of columns for CSV, stdout and XLSX ports output")
+	pflag.StringVar(&config.ServeMock, "serve-mock", "", "Serve a mock XMC on ADDRESS (e.g. 127.0.0.1:8080) instead of querying XMC")
+	pflag.StringVar(&config.MockFixture, "mock-fixture", "campus", "Builtin fixture or fixture file served by serve-mock")
 	pflag.BoolVar(&config.PrintVersion, "version", false, "Print version information and exit")
 	pflag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "%s\n", toolID)
@@ -97,7 +127,10 @@ func parseCLIOptions() {
 		fmt.Fprintf(os.Stderr, "retrieves a list of all VLANs and VLAN to port associations, which is\n")
 		fmt.Fprintf(os.Stderr, "written to outfile.\n")
 		fmt.Fprintf(os.Stderr, "Optionally (but recommended), all found devices are refreshed before\n")
-		fmt.Fprintf(os.Stderr, "retrieving the VLAN data.\n")
+		fmt.Fprintf(os.Stderr, "retrieving the VLAN data. The tool continues as soon as XMC reports a\n")
+		fmt.Fprintf(os.Stderr, "fresh poll for every refreshed device, but waits at most refreshwait minutes.\n")
+		fmt.Fprintf(os.Stderr, "Devices that did not report a fresh poll in time are queried nonetheless,\n")
+		fmt.Fprintf(os.Stderr, "but marked as unfinished in the Refresh column and listed in the summary.\n")
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n", path.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "\n")
@@ -107,35 +140,249 @@ func parseCLIOptions() {
 		fmt.Fprintf(os.Stderr, "It is required to provide at least one outfile. File types are determined\n")
 		fmt.Fprintf(os.Stderr, "by the prefix FILETYPE: or the suffix .FILETYPE. Prefixes take priority\n")
 		fmt.Fprintf(os.Stderr, "over suffixes. Valid FILETYPEs are:\n")
-		fmt.Fprintf(os.Stderr, "  csv     -->  writes CSV data to the given file\n")
-		fmt.Fprintf(os.Stderr, "  json    -->  writes JSON data to the given file\n")
-		fmt.Fprintf(os.Stderr, "  stdout  -->  prints CSV data to stdout\n")
-		fmt.Fprintf(os.Stderr, "  xlsx    -->  writes XLSX data to the given file\n")
+		fmt.Fprintf(os.Stderr, "  csv        -->  writes CSV data to the given file\n")
+		fmt.Fprintf(os.Stderr, "  json       -->  writes JSON data to the given file\n")
+		fmt.Fprintf(os.Stderr, "  stdout     -->  prints CSV data to stdout\n")
+		fmt.Fprintf(os.Stderr, "  xlsx       -->  writes XLSX data to the given file\n")
+		fmt.Fprintf(os.Stderr, "  vlans-csv  -->  writes VLAN-centric CSV data to the given file\n")
+		fmt.Fprintf(os.Stderr, "  vlans-json -->  writes VLAN-centric JSON data to the given file\n")
+		fmt.Fprintf(os.Stderr, "  diff-txt   -->  writes changes compared to diff as text to the given file\n")
+		fmt.Fprintf(os.Stderr, "  diff-json  -->  writes changes compared to diff as JSON to the given file\n")
+		fmt.Fprintf(os.Stderr, "  diff-xlsx  -->  writes changes compared to diff as XLSX to the given file\n")
+		fmt.Fprintf(os.Stderr, "  audit-txt  -->  writes VLAN mismatches between linked devices as text\n")
+		fmt.Fprintf(os.Stderr, "  audit-json -->  writes VLAN mismatches between linked devices as JSON\n")
+		fmt.Fprintf(os.Stderr, "  audit-xlsx -->  writes VLAN mismatches between linked devices as XLSX\n")
+		fmt.Fprintf(os.Stderr, "  names-txt  -->  writes inconsistent VLAN names as text to the given file\n")
+		fmt.Fprintf(os.Stderr, "  names-json -->  writes inconsistent VLAN names as JSON to the given file\n")
+		fmt.Fprintf(os.Stderr, "  names-xlsx -->  writes inconsistent VLAN names as XLSX to the given file\n")
 		fmt.Fprintf(os.Stderr, "When using stdout, you should remove all stderr output (2>/dev/null).\n")
 		fmt.Fprintf(os.Stderr, "The additional suffix .gz can be used to trigger compression.\n")
+		fmt.Fprintf(os.Stderr, "vlans-csv and the XLSX VLAN Usage sheet list ports as IP|PORT.\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "The columns of CSV, stdout and the XLSX ports sheet can be chosen with\n")
+		fmt.Fprintf(os.Stderr, "columns. Columns ending in Names, Types or IPs render VLAN IDs along with\n")
+		fmt.Fprintf(os.Stderr, "the respective detail, e.g. 210(Voice). Available columns are:\n")
+		for _, line := range wrapNames(availableColumns[:], 74) {
+			fmt.Fprintf(os.Stderr, "  %s\n", line)
+		}
+		fmt.Fprintf(os.Stderr, "The LLDP/CDP neighbors of each port are fetched with an additional query\n")
+		fmt.Fprintf(os.Stderr, "per device unless noneighbors is given. Ports whose neighbor is another\n")
+		fmt.Fprintf(os.Stderr, "device managed by XMC are marked as Uplink.\n")
+		fmt.Fprintf(os.Stderr, "Ports that are members of a LAG (MLT, Port-channel) name it in Lag, while\n")
+		fmt.Fprintf(os.Stderr, "the port representing the LAG lists them in LagMembers. With lag-mode\n")
+		fmt.Fprintf(os.Stderr, "collapse, member ports are left out of all outfiles except json, diff and\n")
+		fmt.Fprintf(os.Stderr, "audit.\n")
+		fmt.Fprintf(os.Stderr, "Entries of the vlanList reported by XMC that cannot be parsed are kept in\n")
+		fmt.Fprintf(os.Stderr, "RawVlans instead of being dropped.\n")
+		fmt.Fprintf(os.Stderr, "Devices that are down or could not be refreshed or queried are kept in all\n")
+		fmt.Fprintf(os.Stderr, "outfiles with Status and Error set; XLSX outfiles list them on a Failures\n")
+		fmt.Fprintf(os.Stderr, "sheet.\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "With refreshbatch, several devices are refreshed with a single request.\n")
+		fmt.Fprintf(os.Stderr, "XMC only reports one status for such a request, so the devices of a failed\n")
+		fmt.Fprintf(os.Stderr, "batch are refreshed one by one to find out which of them failed.\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "The devices fetched from XMC can be restricted with include and exclude,\n")
+		fmt.Fprintf(os.Stderr, "both of which can be used multiple times. Valid FIELDs are ip, sysname,\n")
+		fmt.Fprintf(os.Stderr, "location, family, type and site. ip accepts addresses and CIDRs, all other\n")
+		fmt.Fprintf(os.Stderr, "fields accept case-insensitive globs (* and ?) or regular expressions\n")
+		fmt.Fprintf(os.Stderr, "prefixed by \"re:\". A device must match one include per given FIELD and\n")
+		fmt.Fprintf(os.Stderr, "no exclude at all. XMCINCLUDE and XMCEXCLUDE take multiple FIELD=PATTERN\n")
+		fmt.Fprintf(os.Stderr, "rules separated by semicolons and are ignored if the option is given.\n")
+		fmt.Fprintf(os.Stderr, "Alternatively or additionally, a list of device IPs can be provided with\n")
+		fmt.Fprintf(os.Stderr, "devices-file, one per line or comma-separated. IPs that are not managed\n")
+		fmt.Fprintf(os.Stderr, "by XMC are reported and skipped.\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Instead of querying XMC, data can be loaded from a JSON file written by\n")
+		fmt.Fprintf(os.Stderr, "a previous run by using infile. Compressed files are detected automatically.\n")
+		fmt.Fprintf(os.Stderr, "host and the credentials are not required in that case.\n")
+		fmt.Fprintf(os.Stderr, "Combined with diff, two snapshots can be compared without contacting XMC.\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "audit outfiles follow every link between two devices, as known from their\n")
+		fmt.Fprintf(os.Stderr, "LLDP/CDP neighbors, and report VLANs that are tagged on one end only and\n")
+		fmt.Fprintf(os.Stderr, "untagged VLANs that differ between both ends. The JSON variant also lists\n")
+		fmt.Fprintf(os.Stderr, "all consistent links. Links of LAG members are audited using the VLANs of\n")
+		fmt.Fprintf(os.Stderr, "their LAG. xlsx outfiles contain the same report on an Audit sheet.\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "names outfiles group the VLANs of all devices by ID and report VLANs that\n")
+		fmt.Fprintf(os.Stderr, "are known by different names or lack a name on some devices. With\n")
+		fmt.Fprintf(os.Stderr, "vlan-catalog, names are also checked against a reference list given as\n")
+		fmt.Fprintf(os.Stderr, "YAML (\"10: Data\") or CSV (\"10,Data\"), and VLANs missing from it are\n")
+		fmt.Fprintf(os.Stderr, "reported. The JSON variant also lists all consistent VLANs.\n")
+		fmt.Fprintf(os.Stderr, "CSV catalogs are split at the first of comma, semicolon or tab found in\n")
+		fmt.Fprintf(os.Stderr, "their first line and may start with a header such as \"VLAN ID,Name\".\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Long runs can be protected with checkpoint, which records every queried\n")
+		fmt.Fprintf(os.Stderr, "device as soon as it has been fetched. After a crash or interruption, run\n")
+		fmt.Fprintf(os.Stderr, "again with resume to reuse those devices instead of refreshing and\n")
+		fmt.Fprintf(os.Stderr, "querying them again. Without resume, the checkpoint is started over.\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "To reproduce problems without access to XMC, record saves every query and\n")
+		fmt.Fprintf(os.Stderr, "response to a directory. Credentials are not part of either; userid and\n")
+		fmt.Fprintf(os.Stderr, "secret are redacted from recorded errors. replay answers the same queries\n")
+		fmt.Fprintf(os.Stderr, "from that directory later; host and the credentials are not required then\n")
+		fmt.Fprintf(os.Stderr, "and devices are not refreshed.\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "For tests and demos, serve-mock runs a mock XMC that serves the network\n")
+		fmt.Fprintf(os.Stderr, "described by mock-fixture and accepts any credentials. Builtin fixtures\n")
+		fmt.Fprintf(os.Stderr, "are: %s. Query it from a second shell with nohttps.\n", strings.Join(mockxmc.BuiltinFixtureNames(), ", "))
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "At the end of each run, a summary is written as a single line of JSON to\n")
+		fmt.Fprintf(os.Stderr, "stderr or, if summary-file is given, to that file. The exit code is\n")
+		fmt.Fprintf(os.Stderr, "  0  -->  all devices were queried and all outfiles were written\n")
+		fmt.Fprintf(os.Stderr, "  1  -->  fatal error or not a single device could be queried\n")
+		fmt.Fprintf(os.Stderr, "  2  -->  invalid options\n")
+		fmt.Fprintf(os.Stderr, "  3  -->  some devices could not be refreshed or queried\n")
+		fmt.Fprintf(os.Stderr, "  4  -->  at least one outfile could not be written\n")
+		fmt.Fprintf(os.Stderr, "  5  -->  interrupted by SIGINT or SIGTERM; outfiles contain partial results\n")
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "Nearly all options that take a value can be set via environment variables:\n")
 		fmt.Fprintf(os.Stderr, "  XMCHOST             -->  --host\n")
 		fmt.Fprintf(os.Stderr, "  XMCPORT             -->  --port\n")
 		fmt.Fprintf(os.Stderr, "  XMCPATH             -->  --path\n")
 		fmt.Fprintf(os.Stderr, "  XMCTIMEOUT          -->  --timeout\n")
+		fmt.Fprintf(os.Stderr, "  XMCRETRIES          -->  --retries\n")
+		fmt.Fprintf(os.Stderr, "  XMCRETRYDELAY       -->  --retrydelay\n")
 		fmt.Fprintf(os.Stderr, "  XMCNOHTTPS          -->  --nohttps\n")
 		fmt.Fprintf(os.Stderr, "  XMCINSECUREHTTPS    -->  --insecurehttps\n")
 		fmt.Fprintf(os.Stderr, "  XMCUSERID           -->  --userid\n")
 		fmt.Fprintf(os.Stderr, "  XMCSECRET           -->  --secret\n")
 		fmt.Fprintf(os.Stderr, "  XMCBASICAUTH        -->  --basicauth\n")
 		fmt.Fprintf(os.Stderr, "  XMCNOREFRESH        -->  --norefresh\n")
+		fmt.Fprintf(os.Stderr, "  XMCNONEIGHBORS      -->  --noneighbors\n")
 		fmt.Fprintf(os.Stderr, "  XMCREFRESHINTERVAL  -->  --refreshinterval\n")
+		fmt.Fprintf(os.Stderr, "  XMCREFRESHBATCH     -->  --refreshbatch\n")
 		fmt.Fprintf(os.Stderr, "  XMCREFRESHWAIT      -->  --refreshwait\n")
+		fmt.Fprintf(os.Stderr, "  XMCREFRESHPOLL      -->  --refreshpoll\n")
+		fmt.Fprintf(os.Stderr, "  XMCWORKERS          -->  --workers\n")
 		fmt.Fprintf(os.Stderr, "  XMCINCLUDEDOWN      -->  --includedown\n")
+		fmt.Fprintf(os.Stderr, "  XMCDEVICESFILE      -->  --devices-file\n")
+		fmt.Fprintf(os.Stderr, "  XMCINCLUDE          -->  --include\n")
+		fmt.Fprintf(os.Stderr, "  XMCEXCLUDE          -->  --exclude\n")
 		fmt.Fprintf(os.Stderr, "  XMCNOCOLOR          -->  --nocolor\n")
 		fmt.Fprintf(os.Stderr, "  XMCCOMPRESSOUTPUT   -->  --compress-output\n")
+		fmt.Fprintf(os.Stderr, "  XMCINFILE           -->  --infile\n")
+		fmt.Fprintf(os.Stderr, "  XMCDIFF             -->  --diff\n")
+		fmt.Fprintf(os.Stderr, "  XMCVLANCATALOG      -->  --vlan-catalog\n")
+		fmt.Fprintf(os.Stderr, "  XMCCHECKPOINT       -->  --checkpoint\n")
+		fmt.Fprintf(os.Stderr, "  XMCRESUME           -->  --resume\n")
+		fmt.Fprintf(os.Stderr, "  XMCRECORD           -->  --record\n")
+		fmt.Fprintf(os.Stderr, "  XMCREPLAY           -->  --replay\n")
+		fmt.Fprintf(os.Stderr, "  XMCSUMMARYFILE      -->  --summary-file\n")
+		fmt.Fprintf(os.Stderr, "  XMCLAGMODE          -->  --lag-mode\n")
+		fmt.Fprintf(os.Stderr, "  XMCCOLUMNS          -->  --columns\n")
+		fmt.Fprintf(os.Stderr, "  XMCCSVDELIMITER     -->  --csv-delimiter\n")
+		fmt.Fprintf(os.Stderr, "  XMCCSVBOM           -->  --csv-bom\n")
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "Environment variables can also be configured via a file called %s,\n", envFileName)
 		fmt.Fprintf(os.Stderr, "located in the current directory or in the home directory of the current\n")
 		fmt.Fprintf(os.Stderr, "user.\n")
 	}
 	pflag.Parse()
+
+	// include and exclude may be given multiple times, so their environment variables hold a list
+	if !pflag.CommandLine.Changed("include") {
+		config.Include = envFilterRules("XMCINCLUDE")
+	}
+	if !pflag.CommandLine.Changed("exclude") {
+		config.Exclude = envFilterRules("XMCEXCLUDE")
+	}
+}
+
+// Fetches the results for all relevant devices from XMC
+func fetchResults(ctx context.Context, client *xmcnbiclient.NBIClient) (devicesWrapper, error) {
+	initializeClient(client)
+
+	upDevices, downDevices, discoverErr := discoverManagedDevices(ctx, client)
+	if discoverErr != nil {
+		return devicesWrapper{Partial: ctx.Err() != nil}, discoverErr
+	}
+	summary.DevicesDiscovered = len(upDevices) + len(downDevices)
+
+	// Devices from the checkpoint are neither refreshed nor queried again
+	var resumedResults []singleDevice
+	if resumedDevices != nil {
+		var resumedDown []singleDevice
+		upDevices, resumedResults = splitResumedDevices(upDevices, resumedDevices)
+		if config.IncludeDown {
+			downDevices, resumedDown = splitResumedDevices(downDevices, resumedDevices)
+			resumedResults = append(resumedResults, resumedDown...)
+		}
+		summary.DevicesResumed = len(resumedResults)
+		stdErr.Printf("Resuming with %d device(s) from checkpoint <%s>, %d device(s) left to process.\n", len(resumedResults), config.Checkpoint, len(upDevices)+len(downDevices))
+	}
+
+	var rediscoveredDevices []string
+	var refresh refreshOutcome
+	if config.NoRefresh {
+		rediscoveredDevices = upDevices
+	} else {
+		refresh = rediscoverDevices(ctx, client, upDevices)
+		rediscoveredDevices = refresh.Triggered
+		summary.DevicesRefreshed = len(refresh.Triggered)
+		summary.DevicesUnfinished = len(refresh.Unfinished)
+		summary.UnfinishedDevices = refresh.Unfinished
+	}
+	if config.IncludeDown {
+		rediscoveredDevices = append(rediscoveredDevices, downDevices...)
+	}
+	sort.Strings(rediscoveredDevices)
+	summary.DevicesQueried = len(rediscoveredDevices)
+
+	queriedResults := queryDevices(ctx, client, rediscoveredDevices)
+	markRefreshedDevices(queriedResults, refresh)
+	results := append(queriedResults, resumedResults...)
+	// Devices that could not be refreshed are not queried, as their data might be outdated
+	for deviceIP, refreshErr := range refresh.Failed {
+		results = append(results, unqueriedDevice(deviceIP, deviceStatusFailed, refreshErr.Error()))
+	}
+	for _, deviceIP := range refresh.Skipped {
+		results = append(results, unqueriedDevice(deviceIP, deviceStatusSkipped, "run was interrupted before the device was refreshed"))
+	}
+	if !config.IncludeDown {
+		for _, deviceIP := range downDevices {
+			results = append(results, unqueriedDevice(deviceIP, deviceStatusDown, "device is down in XMC and was not queried"))
+		}
+	}
+
+	sortDevices(results)
+
+	return devicesWrapper{Partial: ctx.Err() != nil, Devices: results}, nil
+}
+
+// Reports an invalid option and terminates the app with the exit code pflag uses for invalid options
+func exitInvalidOption(format string, args ...interface{}) {
+	stdErr.Printf(format, args...)
+	os.Exit(exitInvalidOptions)
+}
+
+// Serves a mock XMC until the app is terminated
+func serveMock() {
+	fixture, fixtureErr := mockxmc.OpenFixture(config.MockFixture)
+	if fixtureErr != nil {
+		stdErr.Fatalf("Could not load mock fixture: %s\n", fixtureErr)
+	}
+	mockServer := mockxmc.New(fixture)
+	mockServer.Logf = stdErr.Printf
+
+	stdErr.Printf("Serving mock XMC with %d device(s) on http://%s/, stop with Ctrl+C.\n", len(fixture.Devices), config.ServeMock)
+	stdErr.Fatal(http.ListenAndServe(config.ServeMock, mockServer.Handler()))
+}
+
+// Returns a context that is cancelled on SIGINT or SIGTERM; a second signal terminates the app immediately
+func cancelOnSignal() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		receivedSignal := <-signals
+		stdErr.Printf("Received %s, finishing with the results collected so far. Repeat to abort immediately.\n", receivedSignal)
+		cancel()
+		receivedSignal = <-signals
+		stdErr.Printf("Received %s again, aborting.\n", receivedSignal)
+		os.Exit(exitInterrupted)
+	}()
+	return ctx
 }
 
 /*
@@ -176,47 +423,144 @@ func main() {
 		fmt.Println(toolID)
 		os.Exit(0)
 	}
-	if config.XMCHost == "" {
-		stdErr.Fatal("host is required.")
+	if config.ServeMock != "" {
+		serveMock()
+	}
+	if config.XMCHost == "" && config.Infile == "" && config.Replay == "" {
+		exitInvalidOption("host is required.\n")
 	}
 	if len(config.Outfile) <= 0 {
-		stdErr.Fatal("outfile is required.")
+		exitInvalidOption("outfile is required.\n")
 	}
-
-	initializeClient(&xmcClient)
-
-	upDevices, downDevices := discoverManagedDevices(&xmcClient)
-
-	var rediscoveredDevices []string
-	if config.NoRefresh {
-		rediscoveredDevices = upDevices
-	} else {
-		rediscoveredDevices = rediscoverDevices(&xmcClient, upDevices)
+	if config.Columns != "" {
+		if _, columnsErr := parseColumns(config.Columns); columnsErr != nil {
+			exitInvalidOption("Invalid columns: %s\n", columnsErr)
+		}
 	}
-	if config.IncludeDown {
-		rediscoveredDevices = append(rediscoveredDevices, downDevices...)
+	var filterErr error
+	deviceIncludes, filterErr = parseDeviceFilters(config.Include)
+	if filterErr != nil {
+		exitInvalidOption("Invalid include: %s\n", filterErr)
+	}
+	deviceExcludes, filterErr = parseDeviceFilters(config.Exclude)
+	if filterErr != nil {
+		exitInvalidOption("Invalid exclude: %s\n", filterErr)
+	}
+	if config.DevicesFile != "" && config.Infile == "" {
+		listedIPs, listErr := readDeviceList(config.DevicesFile)
+		if listErr != nil {
+			stdErr.Fatalf("Could not read devices file <%s>: %s\n", config.DevicesFile, listErr)
+		}
+		if len(listedIPs) == 0 {
+			stdErr.Fatalf("Devices file <%s> does not contain any IP addresses.\n", config.DevicesFile)
+		}
+		deviceList = make(map[string]bool)
+		for _, deviceIP := range listedIPs {
+			deviceList[deviceIP] = true
+		}
+	}
+	if _, delimiterErr := csvDelimiter(); delimiterErr != nil {
+		exitInvalidOption("Invalid CSV delimiter: %s\n", delimiterErr)
+	}
+	if !validLagMode(config.LagMode) {
+		exitInvalidOption("lag-mode must be one of %s.\n", strings.Join(validLagModes[:], ", "))
+	}
+	if config.RefreshBatch < 1 {
+		exitInvalidOption("refreshbatch must be at least 1.\n")
+	}
+	if config.RefreshPoll < 1 {
+		exitInvalidOption("refreshpoll must be at least 1.\n")
+	}
+	if config.Workers < 1 {
+		exitInvalidOption("workers must be at least 1.\n")
+	}
+	if config.Resume && config.Checkpoint == "" {
+		exitInvalidOption("resume requires checkpoint.\n")
+	}
+	if config.VlanCatalog != "" {
+		var catalogErr error
+		vlanCatalog, catalogErr = readVlanCatalog(config.VlanCatalog)
+		if catalogErr != nil {
+			stdErr.Fatalf("Could not read VLAN catalog <%s>: %s\n", config.VlanCatalog, catalogErr)
+		}
+	}
+	if config.Record != "" && config.Replay != "" {
+		exitInvalidOption("record and replay cannot be used together.\n")
+	}
+	if config.Record != "" && config.Infile == "" {
+		var recordErr error
+		queryRecorder, recordErr = openRecorder(config.Record)
+		if recordErr != nil {
+			stdErr.Fatalf("Could not record to <%s>: %s\n", config.Record, recordErr)
+		}
+	}
+	if config.Replay != "" && config.Infile == "" {
+		var replayErr error
+		queryReplayer, replayErr = openReplayer(config.Replay)
+		if replayErr != nil {
+			stdErr.Fatalf("Could not replay from <%s>: %s\n", config.Replay, replayErr)
+		}
+		// refreshing depends on XMC reporting new polls over time, which a recording cannot reproduce
+		config.NoRefresh = true
+	}
+	if config.Checkpoint != "" && config.Infile == "" {
+		var checkpointErr error
+		if config.Resume {
+			resumedDevices, checkpointErr = readCheckpoint(config.Checkpoint)
+			if checkpointErr != nil {
+				stdErr.Fatalf("Could not read checkpoint <%s>: %s\n", config.Checkpoint, checkpointErr)
+			}
+		}
+		checkpoint, checkpointErr = openCheckpoint(config.Checkpoint, config.Resume)
+		if checkpointErr != nil {
+			stdErr.Fatalf("Could not open checkpoint <%s>: %s\n", config.Checkpoint, checkpointErr)
+		}
 	}
-	sort.Strings(rediscoveredDevices)
 
-	queryResults := []singleDevice{}
-	for _, deviceIP := range rediscoveredDevices {
-		deviceResult, deviceErr := queryDevice(&xmcClient, deviceIP)
-		if deviceErr != nil {
-			stdErr.Println(deviceErr)
-			continue
+	var queryResults devicesWrapper
+	if config.Infile != "" {
+		infileResults, infileErr := readResultsJSON(config.Infile)
+		summary.Infile = config.Infile
+		if infileErr != nil {
+			stdErr.Printf("Could not read infile <%s>: %s\n", config.Infile, infileErr)
+			summary.Error = infileErr.Error()
+			exitWithSummary(nil)
+		}
+		stdErr.Printf("Loaded %d devices from <%s>.\n", len(infileResults.Devices), config.Infile)
+		queryResults = infileResults
+	} else {
+		var fetchErr error
+		queryResults, fetchErr = fetchResults(cancelOnSignal(), &xmcClient)
+		if checkpoint != nil {
+			if closeErr := checkpoint.Close(); closeErr != nil {
+				stdErr.Printf("Could not close checkpoint <%s>: %s\n", config.Checkpoint, closeErr)
+			}
+		}
+		summary.Interrupted = queryResults.Partial
+		if fetchErr != nil {
+			stdErr.Println(fetchErr)
+			summary.Error = fetchErr.Error()
+			exitWithSummary(nil)
+		}
+		if queryResults.Partial {
+			stdErr.Printf("Run was interrupted, writing partial results for %d device(s).\n", len(queryResults.Devices))
 		}
-		queryResults = append(queryResults, deviceResult)
 	}
-	sort.Slice(queryResults, func(i, j int) bool { return queryResults[i].ID < queryResults[j].ID })
 
 	var writeRows uint
 	var writeErr error
 	for _, outfile := range config.Outfile {
-		writeRows, writeErr = writeResults(outfile, devicesWrapper{queryResults})
+		writeRows, writeErr = writeResults(outfile, queryResults)
 		if writeErr != nil {
 			stdErr.Println(writeErr)
 		} else {
 			stdErr.Printf("%d rows written to <%s>.\n", writeRows, outfile)
 		}
+		summary.AddOutfile(outfile, writeRows, writeErr)
 	}
+	if retries := atomic.LoadUint64(&retryCount); retries > 0 {
+		stdErr.Printf("%d request(s) to XMC had to be retried.\n", retries)
+	}
+
+	exitWithSummary(queryResults.Devices)
 }
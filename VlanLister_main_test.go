@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestInvalidOptionsExitCode(t *testing.T) {
+	// The subprocess runs main with the arguments given by the parent test
+	if args := os.Getenv("VLANLISTER_TEST_ARGS"); args != "" {
+		os.Args = append([]string{"xmc-nbi-vlanlister-go"}, strings.Split(args, " ")...)
+		main()
+		return
+	}
+
+	tests := []string{
+		"-h xmc.example.com",
+		"-h xmc.example.com --outfile out.csv --workers 0",
+		"-h xmc.example.com --outfile out.csv --refreshbatch 0",
+		"-h xmc.example.com --outfile out.csv --columns IP,Unknown",
+		"-h xmc.example.com --outfile out.csv --include unknown=x",
+		"-h xmc.example.com --outfile out.csv --exclude ip=10.0.0.0/33",
+		"-h xmc.example.com --outfile out.csv --lag-mode merge",
+		"-h xmc.example.com --outfile out.csv --csv-delimiter ab",
+		"-h xmc.example.com --outfile out.csv --resume",
+		"-h xmc.example.com --outfile out.csv --record a --replay b",
+		"-h xmc.example.com --outfile out.csv --unknown-option",
+	}
+	for _, args := range tests {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestInvalidOptionsExitCode$")
+		cmd.Env = append(os.Environ(), "VLANLISTER_TEST_ARGS="+args)
+		runErr := cmd.Run()
+		var exitErr *exec.ExitError
+		if !errors.As(runErr, &exitErr) || exitErr.ExitCode() != exitInvalidOptions {
+			t.Errorf("running with %q returned %v, want exit code %d", args, runErr, exitInvalidOptions)
+		}
+	}
+
+	// Options that are valid but fail at runtime must not be reported as invalid
+	cmd := exec.Command(os.Args[0], "-test.run=^TestInvalidOptionsExitCode$")
+	cmd.Env = append(os.Environ(), "VLANLISTER_TEST_ARGS=--infile "+t.TempDir()+"/missing.json --outfile "+t.TempDir()+"/out.csv")
+	var exitErr *exec.ExitError
+	if runErr := cmd.Run(); !errors.As(runErr, &exitErr) || exitErr.ExitCode() != exitTotalFailure {
+		t.Errorf("running with a missing infile returned %v, want exit code %d", runErr, exitTotalFailure)
+	}
+}
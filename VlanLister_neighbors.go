@@ -0,0 +1,140 @@
+package main
+
+/*
+#### ##     ## ########   #######  ########  ########  ######
+ ##  ###   ### ##     ## ##     ## ##     ##    ##    ##    ##
+ ##  #### #### ##     ## ##     ## ##     ##    ##    ##
+ ##  ## ### ## ########  ##     ## ########     ##     ######
+ ##  ##     ## ##        ##     ## ##   ##      ##          ##
+ ##  ##     ## ##        ##     ## ##    ##     ##    ##    ##
+#### ##     ## ##         #######  ##     ##    ##     ######
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	xmcnbiclient "gitlab.com/rbrt-weiler/go-module-xmcnbiclient"
+)
+
+/*
+##     ##    ###    ########   ######
+##     ##   ## ##   ##     ## ##    ##
+##     ##  ##   ##  ##     ## ##
+##     ## ##     ## ########   ######
+ ##   ##  ######### ##   ##         ##
+  ## ##   ##     ## ##    ##  ##    ##
+   ###    ##     ## ##     ##  ######
+*/
+
+var (
+	// sysNames of all devices managed by XMC, keyed by IP address; filled during discovery
+	managedDevices map[string]string
+)
+
+/*
+######## ##     ## ##    ##  ######   ######
+##       ##     ## ###   ## ##    ## ##    ##
+##       ##     ## ####  ## ##       ##
+######   ##     ## ## ## ## ##        ######
+##       ##     ## ##  #### ##             ##
+##       ##     ## ##   ### ##    ## ##    ##
+##        #######  ##    ##  ######   ######
+*/
+
+// Fetches the LLDP/CDP neighbors of a device from XMC and attaches them to its ports
+func fetchNeighbors(ctx context.Context, client *xmcnbiclient.NBIClient, device *singleDevice) error {
+	body, bodyErr := queryAPI(ctx, client, fmt.Sprintf(gqlDeviceNeighborsQuery, graphQLString(device.IPAddress)))
+	if bodyErr != nil {
+		return bodyErr
+	}
+
+	jsonData := xmcDeviceNeighbors{}
+	if jsonErr := json.Unmarshal(body, &jsonData); jsonErr != nil {
+		return fmt.Errorf("Could not decode JSON: %s", jsonErr)
+	}
+	if len(jsonData.Errors) > 0 {
+		return fmt.Errorf("XMC returned error: %s", jsonData.Errors[0].Message)
+	}
+
+	portsByIndex := make(map[int]*devicePort)
+	portsByName := make(map[string]*devicePort)
+	for i := range device.Ports {
+		portsByIndex[device.Ports[i].Index] = &device.Ports[i]
+		portsByName[device.Ports[i].Name] = &device.Ports[i]
+	}
+
+	var unknownPorts int
+	for _, neighbor := range jsonData.Data.Network.DeviceNeighbors {
+		port, found := portsByIndex[neighbor.LocalIfIndex]
+		if !found {
+			port, found = portsByName[neighbor.LocalPort]
+		}
+		if !found {
+			unknownPorts++
+			continue
+		}
+		port.Neighbors = append(port.Neighbors, portNeighbor{
+			SysName:  neighbor.RemoteSysName,
+			Port:     neighbor.RemotePort,
+			IP:       neighbor.RemoteIP,
+			Protocol: neighbor.Protocol,
+		})
+	}
+	if unknownPorts > 0 {
+		stdErr.Printf("Ignored %d neighbor(s) of %s on unknown ports.\n", unknownPorts, device.IPAddress)
+	}
+
+	markUplinks(device.Ports)
+	return nil
+}
+
+// Reports whether two sysNames refer to the same host, ignoring case and domain
+func sameHostname(a string, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	return strings.EqualFold(strings.SplitN(a, ".", 2)[0], strings.SplitN(b, ".", 2)[0])
+}
+
+// Reports whether a neighbor is a device managed by XMC, identified by its IP address or sysName
+func isManagedNeighbor(neighbor portNeighbor) bool {
+	if neighbor.IP != "" {
+		if _, found := managedDevices[canonicalIP(neighbor.IP)]; found {
+			return true
+		}
+	}
+	// LLDP often reports the FQDN while XMC knows the hostname only, or vice versa
+	for _, sysName := range managedDevices {
+		if sameHostname(sysName, neighbor.SysName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Marks all ports that have another managed device as neighbor as uplinks
+func markUplinks(ports []devicePort) {
+	for i := range ports {
+		ports[i].Uplink = false
+		for _, neighbor := range ports[i].Neighbors {
+			if isManagedNeighbor(neighbor) {
+				ports[i].Uplink = true
+			}
+		}
+	}
+}
+
+// Joins a single attribute of all neighbors of a port, e.g. their sysNames
+func joinNeighbors(port *devicePort, attribute func(portNeighbor) string) string {
+	if port == nil {
+		return ""
+	}
+	var values []string
+	for _, neighbor := range port.Neighbors {
+		values = append(values, attribute(neighbor))
+	}
+	return strings.Join(values, ",")
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMarkUplinks(t *testing.T) {
+	managedDevices = map[string]string{"10.0.0.1": "core-sw1", "10.0.0.2": "access-sw1.campus.example.com"}
+	defer func() { managedDevices = nil }()
+
+	ports := []devicePort{
+		{Name: "1", Neighbors: []portNeighbor{{SysName: "unknown", IP: "10.0.0.1"}}},
+		{Name: "2", Neighbors: []portNeighbor{{SysName: "CORE-SW1.campus.example.com"}}},
+		{Name: "3", Neighbors: []portNeighbor{{SysName: "access-sw1", IP: "192.0.2.1"}}},
+		{Name: "4", Neighbors: []portNeighbor{{SysName: "SEP001122334455", IP: "192.0.2.101"}}},
+		{Name: "5", Neighbors: []portNeighbor{{SysName: "phone"}, {SysName: "core-sw1"}}},
+		{Name: "6", Uplink: true},
+	}
+	wantUplinks := []bool{true, true, true, false, true, false}
+
+	markUplinks(ports)
+	for i, port := range ports {
+		if port.Uplink != wantUplinks[i] {
+			t.Errorf("port %s has uplink %t, want %t", port.Name, port.Uplink, wantUplinks[i])
+		}
+	}
+}
@@ -11,11 +11,12 @@ package main
 */
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	xmcnbiclient "gitlab.com/rbrt-weiler/go-module-xmcnbiclient"
@@ -38,6 +39,23 @@ const (
 				devices {
 					up
 					ip
+					sysName
+					sysLocation
+					siteName
+					deviceDisplayFamily
+					deviceData {
+						subFamily
+					}
+				}
+			}
+		}
+	`
+	gqlDevicePollQuery string = `
+		query {
+			network {
+				devices {
+					ip
+					lastUpdate
 				}
 			}
 		}
@@ -45,17 +63,32 @@ const (
 	gqlMutationQuery string = `
 		mutation {
 			network {
-				rediscoverDevices(input: {devices: [{ipAddress: "%s"}]}) {
+				rediscoverDevices(input: {devices: [%s]}) {
 					status
 					message
 				}
 			}
 		}
 	`
+	gqlMutationDevice       string = `{ipAddress: %s}`
+	gqlDeviceNeighborsQuery string = `
+		query {
+			network {
+				deviceNeighbors(ip: %s) {
+					localIfIndex
+					localPort
+					remoteSysName
+					remotePort
+					remoteIp
+					protocol
+				}
+			}
+		}
+	`
 	gqlDeviceDataQuery string = `
 		query {
 			network {
-				device(ip: "%s") {
+				device(ip: %s) {
 					id
 					up
 					baseMac
@@ -68,13 +101,19 @@ const (
 							ifIndex
 							ifPhysAddress
 							ifName
+							ifAlias
+							ifDescr
 							ifAdminStatus
 							ifOperStatus
+							ifSpeed
+							duplex
+							ifType
+							lagIfIndex
 							vlanList
 						}
 					}
 				}
-				deviceVlans(ip: "%s") {
+				deviceVlans(ip: %s) {
 					type
 					vid
 					name
@@ -96,25 +135,54 @@ const (
 ##        #######  ##    ##  ######   ######
 */
 
+// Quotes a value as a GraphQL string literal, escaping quotes, backslashes and control characters
+func graphQLString(value string) string {
+	// JSON string literals are valid GraphQL string literals
+	quoted, _ := json.Marshal(value)
+	return string(quoted)
+}
+
 // Fetches the complete list of managed devices from XMC
-func discoverManagedDevices(client *xmcnbiclient.NBIClient) ([]string, []string) {
+func discoverManagedDevices(ctx context.Context, client *xmcnbiclient.NBIClient) ([]string, []string, error) {
 	stdErr.Println("Discovering managed devices...")
 
-	body, bodyErr := client.QueryAPI(gqlDeviceListQuery)
+	body, bodyErr := queryAPI(ctx, client, gqlDeviceListQuery)
 	if bodyErr != nil {
-		stdErr.Fatalf("Could not fetch device list: %s\n", bodyErr)
+		return nil, nil, fmt.Errorf("Could not fetch device list: %s", bodyErr)
 	}
-	proactiveTokenRefresh(client)
 
 	devices := xmcDeviceList{}
 	jsonErr := json.Unmarshal(body, &devices)
 	if jsonErr != nil {
-		stdErr.Fatalf("Could not decode JSON: %s\n", jsonErr)
+		return nil, nil, fmt.Errorf("Could not decode JSON: %s", jsonErr)
 	}
 
 	var upDevices []string
 	var downDevices []string
+	var skippedDevices int
+	listedDevices := make(map[string]bool)
+	managedDevices = make(map[string]string)
 	for _, d := range devices.Data.Network.Devices {
+		managedDevices[canonicalIP(d.IP)] = d.SysName
+		if deviceList != nil {
+			if !deviceList[canonicalIP(d.IP)] {
+				continue
+			}
+			listedDevices[canonicalIP(d.IP)] = true
+		}
+		device := managedDevice{
+			IP:          d.IP,
+			Up:          d.Up,
+			SysName:     d.SysName,
+			SysLocation: d.SysLocation,
+			Family:      d.DeviceDisplayFamily,
+			Type:        d.DeviceData.SubFamily,
+			Site:        d.SiteName,
+		}
+		if !deviceSelected(&device) {
+			skippedDevices++
+			continue
+		}
 		if d.Up {
 			upDevices = append(upDevices, d.IP)
 		} else {
@@ -122,58 +190,257 @@ func discoverManagedDevices(client *xmcnbiclient.NBIClient) ([]string, []string)
 		}
 	}
 	sort.Strings(upDevices)
+	var unmanagedDevices []string
+	for deviceIP := range deviceList {
+		if !listedDevices[deviceIP] {
+			unmanagedDevices = append(unmanagedDevices, deviceIP)
+		}
+	}
+	sort.Strings(unmanagedDevices)
+	for _, deviceIP := range unmanagedDevices {
+		stdErr.Printf("Device %s is not managed by XMC and will be skipped.\n", deviceIP)
+	}
+	if skippedDevices > 0 {
+		stdErr.Printf("Skipped %d device(s) based on filters.\n", skippedDevices)
+	}
 	stdErr.Println("Finished discovering managed devices.")
 
-	return upDevices, downDevices
+	return upDevices, downDevices, nil
 }
 
-// Triggers a rediscover for a list of devices
-func rediscoverDevices(client *xmcnbiclient.NBIClient, ipList []string) []string {
-	var rediscoveredDevices []string
+// Fetches the timestamp of the last poll for all managed devices from XMC
+func fetchLastPolls(ctx context.Context, client *xmcnbiclient.NBIClient) (map[string]int64, error) {
+	body, bodyErr := queryAPI(ctx, client, gqlDevicePollQuery)
+	if bodyErr != nil {
+		return nil, fmt.Errorf("Could not fetch device poll status: %s", bodyErr)
+	}
+
+	devices := xmcDevicePollList{}
+	jsonErr := json.Unmarshal(body, &devices)
+	if jsonErr != nil {
+		return nil, fmt.Errorf("Could not decode JSON: %s", jsonErr)
+	}
+
+	lastPolls := make(map[string]int64)
+	for _, d := range devices.Data.Network.Devices {
+		lastPolls[d.IP] = d.LastUpdate
+	}
+
+	return lastPolls, nil
+}
+
+// Sends a single rediscover mutation for a batch of devices.
+// The rediscoverDevices mutation of the NBI returns no per-device results, only one status for the
+// whole mutation, so an error applies to the batch as a whole; mutateDevices narrows it down.
+func sendRediscoverMutation(ctx context.Context, client *xmcnbiclient.NBIClient, ipList []string) error {
+	var mutationDevices []string
 	for _, deviceIP := range ipList {
-		body, bodyErr := client.QueryAPI(fmt.Sprintf(gqlMutationQuery, deviceIP))
-		if bodyErr != nil {
-			stdErr.Printf("Could not mutate device %s: %s\n", deviceIP, bodyErr)
+		mutationDevices = append(mutationDevices, fmt.Sprintf(gqlMutationDevice, graphQLString(deviceIP)))
+	}
+
+	body, bodyErr := mutateAPI(ctx, client, fmt.Sprintf(gqlMutationQuery, strings.Join(mutationDevices, ", ")))
+	if bodyErr != nil {
+		return fmt.Errorf("Could not mutate device(s) %s: %s", strings.Join(ipList, ", "), bodyErr)
+	}
+
+	mutation := xmcMutationMessage{}
+	jsonErr := json.Unmarshal(body, &mutation)
+	if jsonErr != nil {
+		return fmt.Errorf("Could not decode JSON: %s", jsonErr)
+	}
+	if mutation.Data.Network.RediscoverDevices.Status != "SUCCESS" {
+		return fmt.Errorf("Rediscover for %s failed: %s", strings.Join(ipList, ", "), mutation.Data.Network.RediscoverDevices.Message)
+	}
+
+	return nil
+}
+
+// Triggers a rediscover for a batch of devices; returns the devices that were triggered and the errors of those that were not.
+// A failed batch is retried one device at a time, so that each failure is tied to the device causing it.
+// Devices that are neither triggered nor failed were left out because ctx was cancelled.
+func mutateDevices(ctx context.Context, client *xmcnbiclient.NBIClient, ipList []string) ([]string, map[string]error) {
+	var triggeredDevices []string
+	failedDevices := make(map[string]error)
+
+	batchErr := sendRediscoverMutation(ctx, client, ipList)
+	if batchErr == nil {
+		return ipList, failedDevices
+	}
+	if ctx.Err() != nil {
+		return triggeredDevices, failedDevices
+	}
+	stdErr.Println(batchErr)
+	if len(ipList) == 1 {
+		failedDevices[ipList[0]] = batchErr
+		return triggeredDevices, failedDevices
+	}
+
+	stdErr.Printf("Falling back to refreshing %d device(s) one by one...\n", len(ipList))
+	for _, deviceIP := range ipList {
+		stdErr.Printf("Waiting for %d second(s)...\n", config.RefreshInterval)
+		if sleepContext(ctx, time.Second*time.Duration(config.RefreshInterval)) != nil {
+			break
+		}
+		singleErr := sendRediscoverMutation(ctx, client, []string{deviceIP})
+		if ctx.Err() != nil {
+			break
+		}
+		if singleErr != nil {
+			stdErr.Println(singleErr)
+			failedDevices[deviceIP] = singleErr
 			continue
 		}
-		proactiveTokenRefresh(client)
+		triggeredDevices = append(triggeredDevices, deviceIP)
+	}
 
-		mutation := xmcMutationMessage{}
-		jsonErr := json.Unmarshal(body, &mutation)
-		if jsonErr != nil {
-			stdErr.Printf("Could not decode JSON: %s\n", jsonErr)
-			continue
+	return triggeredDevices, failedDevices
+}
+
+// Triggers a rediscover for a list of devices
+func rediscoverDevices(ctx context.Context, client *xmcnbiclient.NBIClient, ipList []string) refreshOutcome {
+	outcome := refreshOutcome{Failed: make(map[string]error)}
+
+	if len(ipList) == 0 {
+		return outcome
+	}
+
+	// Remember the last poll before triggering the rediscover to detect fresh polls later on
+	lastPolls, pollErr := fetchLastPolls(ctx, client)
+	if pollErr != nil {
+		stdErr.Println(pollErr)
+		lastPolls = make(map[string]int64)
+	}
+	previousPolls := make(map[string]int64)
+
+	batchSize := int(config.RefreshBatch)
+	for batchStart := 0; batchStart < len(ipList) && ctx.Err() == nil; batchStart += batchSize {
+		batchEnd := batchStart + batchSize
+		if batchEnd > len(ipList) {
+			batchEnd = len(ipList)
 		}
+		batch := ipList[batchStart:batchEnd]
 
-		if mutation.Data.Network.RediscoverDevices.Status == "SUCCESS" {
+		triggeredAt := time.Now().UnixNano() / int64(time.Millisecond)
+		triggeredDevices, failedDevices := mutateDevices(ctx, client, batch)
+		for deviceIP, mutationErr := range failedDevices {
+			outcome.Failed[deviceIP] = mutationErr
+		}
+
+		for _, deviceIP := range triggeredDevices {
 			stdErr.Printf("Successfully triggered rediscover for %s.\n", deviceIP)
-			rediscoveredDevices = append(rediscoveredDevices, deviceIP)
-		} else {
-			stdErr.Printf("Rediscover for %s failed: %s\n", deviceIP, mutation.Data.Network.RediscoverDevices.Message)
+			outcome.Triggered = append(outcome.Triggered, deviceIP)
+			if lastPoll, found := lastPolls[deviceIP]; found {
+				previousPolls[deviceIP] = lastPoll
+			} else {
+				previousPolls[deviceIP] = triggeredAt
+			}
 		}
 
 		stdErr.Printf("Waiting for %d second(s)...\n", config.RefreshInterval)
-		time.Sleep(time.Second * time.Duration(config.RefreshInterval))
+		sleepContext(ctx, time.Second*time.Duration(config.RefreshInterval))
+	}
+	if ctx.Err() != nil {
+		stdErr.Printf("Stopped refreshing devices after triggering %d of %d.\n", len(outcome.Triggered), len(ipList))
+		for _, deviceIP := range ipList {
+			_, triggered := previousPolls[deviceIP]
+			if _, failed := outcome.Failed[deviceIP]; !triggered && !failed {
+				outcome.Skipped = append(outcome.Skipped, deviceIP)
+			}
+		}
+		return outcome
+	}
+
+	outcome.Unfinished = waitForRediscover(ctx, client, previousPolls)
+	if len(outcome.Unfinished) > 0 {
+		stdErr.Printf("Rediscover did not finish within %d minute(s) for %d device(s): %s\n", config.RefreshWait, len(outcome.Unfinished), strings.Join(outcome.Unfinished, ", "))
+	}
+
+	return outcome
+}
+
+// Marks queried devices with the outcome of their refresh; nothing is marked if refreshes were not waited for
+func markRefreshedDevices(devices []singleDevice, refresh refreshOutcome) {
+	if config.NoRefresh || config.RefreshWait == 0 {
+		return
+	}
+
+	refreshStates := make(map[string]string)
+	for _, deviceIP := range refresh.Triggered {
+		refreshStates[canonicalIP(deviceIP)] = refreshFinished
+	}
+	for _, deviceIP := range refresh.Unfinished {
+		refreshStates[canonicalIP(deviceIP)] = refreshUnfinished
 	}
-	for i := config.RefreshWait; i > 0; i-- {
-		proactiveTokenRefresh(client)
-		stdErr.Printf("Waiting for %d minute(s) to finish rediscover...\n", i)
-		time.Sleep(time.Minute * time.Duration(1))
+	for i := range devices {
+		devices[i].Refresh = refreshStates[canonicalIP(devices[i].IPAddress)]
 	}
-	return rediscoveredDevices
+}
+
+// Polls XMC until all devices report a fresh poll or RefreshWait is over; returns the devices that did not finish
+func waitForRediscover(ctx context.Context, client *xmcnbiclient.NBIClient, previousPolls map[string]int64) []string {
+	var unfinishedDevices []string
+
+	if config.RefreshWait == 0 {
+		return unfinishedDevices
+	}
+
+	pendingDevices := make(map[string]int64)
+	for deviceIP, lastPoll := range previousPolls {
+		pendingDevices[deviceIP] = lastPoll
+	}
+
+	deadline := time.Now().Add(time.Minute * time.Duration(config.RefreshWait))
+	for len(pendingDevices) > 0 {
+		lastPolls, pollErr := fetchLastPolls(ctx, client)
+		if ctx.Err() != nil {
+			break
+		}
+		if pollErr != nil {
+			stdErr.Println(pollErr)
+		}
+		for deviceIP, lastPoll := range lastPolls {
+			previousPoll, pending := pendingDevices[deviceIP]
+			if pending && lastPoll > previousPoll {
+				stdErr.Printf("Rediscover finished for %s.\n", deviceIP)
+				delete(pendingDevices, deviceIP)
+			}
+		}
+		if len(pendingDevices) == 0 {
+			break
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		pollInterval := time.Second * time.Duration(config.RefreshPoll)
+		if pollInterval > remaining {
+			pollInterval = remaining
+		}
+		stdErr.Printf("Waiting for rediscover to finish for %d device(s), at most %s left...\n", len(pendingDevices), remaining.Round(time.Second))
+		if sleepContext(ctx, pollInterval) != nil {
+			break
+		}
+	}
+
+	for deviceIP := range pendingDevices {
+		unfinishedDevices = append(unfinishedDevices, deviceIP)
+	}
+	sort.Strings(unfinishedDevices)
+
+	return unfinishedDevices
 }
 
 // Fetches the detailed data for a single device from XMC
-func queryDevice(client *xmcnbiclient.NBIClient, deviceIP string) (singleDevice, error) {
+func queryDevice(ctx context.Context, client *xmcnbiclient.NBIClient, deviceIP string) (singleDevice, error) {
 	var deviceResult singleDevice
 
 	deviceResult.QueriedAt = time.Now().Format(time.RFC3339)
 
-	body, bodyErr := client.QueryAPI(fmt.Sprintf(gqlDeviceDataQuery, deviceIP, deviceIP))
+	body, bodyErr := queryAPI(ctx, client, fmt.Sprintf(gqlDeviceDataQuery, graphQLString(deviceIP), graphQLString(deviceIP)))
 	if bodyErr != nil {
 		return deviceResult, fmt.Errorf("Could not query device %s: %s", deviceIP, bodyErr)
 	}
-	proactiveTokenRefresh(client)
 
 	jsonData := xmcDeviceData{}
 	jsonErr := json.Unmarshal(body, &jsonData)
@@ -182,6 +449,9 @@ func queryDevice(client *xmcnbiclient.NBIClient, deviceIP string) (singleDevice,
 	}
 
 	device := jsonData.Data.Network.Device
+	if device.IP == "" {
+		return deviceResult, fmt.Errorf("Could not query device %s: XMC returned no data", deviceIP)
+	}
 	vlans := jsonData.Data.Network.DeviceVlans
 	ports := jsonData.Data.Network.Device.EntityData.AllPorts
 
@@ -189,6 +459,10 @@ func queryDevice(client *xmcnbiclient.NBIClient, deviceIP string) (singleDevice,
 
 	deviceResult.ID = device.ID
 	deviceResult.Up = device.Up
+	deviceResult.Status = deviceStatusOK
+	if !device.Up {
+		deviceResult.Status = deviceStatusDown
+	}
 	deviceResult.BaseMAC = device.BaseMac
 	deviceResult.IPAddress = device.IP
 	deviceResult.SysName = device.SysName
@@ -206,30 +480,108 @@ func queryDevice(client *xmcnbiclient.NBIClient, deviceIP string) (singleDevice,
 	}
 	sort.Slice(deviceResult.Vlans, func(i, j int) bool { return deviceResult.Vlans[i].ID < deviceResult.Vlans[j].ID })
 
+	rawEntries := 0
 	for _, port := range ports {
 		portResult := devicePort{}
 		portResult.Index = port.IfIndex
 		portResult.MACAddress = port.IfPhysAddress
 		portResult.Name = port.IfName
+		portResult.Alias = port.IfAlias
+		portResult.Description = port.IfDescr
 		portResult.AdminStatus = port.IfAdminStatus
 		portResult.OperStatus = port.IfOperStatus
-		for _, vlan := range port.VlanList {
-			vid, vidError := strconv.Atoi(strings.Split(vlan, "[")[0])
-			if vidError != nil {
-				stdErr.Printf("Could not convert VLAN ID: %s\n", vidError)
-				continue
-			}
-			if strings.Contains(vlan, "Untagged") {
-				portResult.UntaggedVlans = append(portResult.UntaggedVlans, vid)
-			} else if strings.Contains(vlan, "Tagged") {
-				portResult.TaggedVlans = append(portResult.TaggedVlans, vid)
-			}
-		}
-		sort.Slice(portResult.UntaggedVlans, func(i, j int) bool { return portResult.UntaggedVlans[i] < portResult.UntaggedVlans[i] })
-		sort.Slice(portResult.TaggedVlans, func(i, j int) bool { return portResult.TaggedVlans[i] < portResult.TaggedVlans[i] })
+		portResult.Speed = string(port.IfSpeed)
+		portResult.Duplex = string(port.Duplex)
+		portResult.Type = string(port.IfType)
+		portResult.LagIfIndex = port.LagIfIndex
+		vlanList := parseVlanList(port.VlanList)
+		portResult.UntaggedVlans = vlanList.Untagged
+		portResult.TaggedVlans = vlanList.Tagged
+		portResult.ForbiddenVlans = vlanList.Forbidden
+		portResult.RawVlans = vlanList.Raw
+		rawEntries += len(vlanList.Raw)
 		deviceResult.Ports = append(deviceResult.Ports, portResult)
 	}
 	sort.Slice(deviceResult.Ports, func(i, j int) bool { return deviceResult.Ports[i].Index < deviceResult.Ports[j].Index })
+	linkLagMembers(deviceResult.Ports)
+	if !config.NoNeighbors && device.Up {
+		if neighborErr := fetchNeighbors(ctx, client, &deviceResult); neighborErr != nil {
+			stdErr.Printf("Could not fetch neighbors of %s: %s\n", device.IP, neighborErr)
+		}
+	}
+	if rawEntries > 0 {
+		stdErr.Printf("Could not parse %d vlanList entries of %s, kept them as RawVlans.\n", rawEntries, device.IP)
+	}
 
 	return deviceResult, nil
 }
+
+// Returns a placeholder for a device that has not been queried, so that it still shows up in outfiles
+func unqueriedDevice(deviceIP string, status string, reason string) singleDevice {
+	return singleDevice{
+		QueriedAt: time.Now().Format(time.RFC3339),
+		IPAddress: deviceIP,
+		Status:    status,
+		Error:     reason,
+	}
+}
+
+// Fetches the detailed data for a list of devices from XMC using a pool of workers
+func queryDevices(ctx context.Context, client *xmcnbiclient.NBIClient, ipList []string) []singleDevice {
+	var workerGroup sync.WaitGroup
+	var resultsMutex sync.Mutex
+
+	queryResults := []singleDevice{}
+	deviceQueue := make(chan string)
+
+	for w := uint(0); w < config.Workers; w++ {
+		workerGroup.Add(1)
+		go func() {
+			defer workerGroup.Done()
+			for deviceIP := range deviceQueue {
+				deviceResult, deviceErr := queryDevice(ctx, client, deviceIP)
+				if deviceErr == nil && checkpoint != nil {
+					if checkpointErr := checkpoint.Add(deviceResult); checkpointErr != nil {
+						stdErr.Println(checkpointErr)
+					}
+				}
+				if deviceErr != nil {
+					stdErr.Println(deviceErr)
+					if ctx.Err() != nil {
+						deviceResult = unqueriedDevice(deviceIP, deviceStatusSkipped, "run was interrupted while querying the device")
+					} else {
+						deviceResult = unqueriedDevice(deviceIP, deviceStatusFailed, deviceErr.Error())
+					}
+				}
+				resultsMutex.Lock()
+				queryResults = append(queryResults, deviceResult)
+				resultsMutex.Unlock()
+			}
+		}()
+	}
+	var skippedDevices []string
+	for i, deviceIP := range ipList {
+		if ctx.Err() == nil {
+			select {
+			case deviceQueue <- deviceIP:
+				continue
+			case <-ctx.Done():
+			}
+		}
+		skippedDevices = ipList[i:]
+		break
+	}
+	close(deviceQueue)
+	workerGroup.Wait()
+
+	if len(skippedDevices) > 0 {
+		stdErr.Printf("Skipped querying %d device(s) due to interruption.\n", len(skippedDevices))
+	}
+	for _, deviceIP := range skippedDevices {
+		queryResults = append(queryResults, unqueriedDevice(deviceIP, deviceStatusSkipped, "run was interrupted before the device was queried"))
+	}
+
+	sortDevices(queryResults)
+
+	return queryResults
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	xmcnbiclient "gitlab.com/rbrt-weiler/go-module-xmcnbiclient"
+)
+
+func TestQueryDevicesCancelled(t *testing.T) {
+	var client xmcnbiclient.NBIClient
+
+	server, _, apiRequests := newTestXMC(t, "")
+	configureTestXMC(t, server, "")
+	config.BasicAuth = true
+	config.Workers = 2
+	initializeClient(&client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := queryDevices(ctx, &client, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"})
+	if len(results) != 3 {
+		t.Fatalf("queryDevices() returned %d device(s), want 3", len(results))
+	}
+	for _, dev := range results {
+		if dev.Status != deviceStatusSkipped {
+			t.Errorf("device %s has status %q, want %q", dev.IPAddress, dev.Status, deviceStatusSkipped)
+		}
+	}
+	if *apiRequests != 0 {
+		t.Errorf("API endpoint was requested %d time(s), want 0", *apiRequests)
+	}
+}
+
+func TestLooseStringUnmarshal(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    looseString
+		wantErr bool
+	}{
+		{`"full"`, "full", false},
+		{`1000000000`, "1000000000", false},
+		{`6`, "6", false},
+		{`null`, "", false},
+		{`["full"]`, "", true},
+	}
+	for _, test := range tests {
+		var got looseString
+		unmarshalErr := json.Unmarshal([]byte(test.data), &got)
+		if (unmarshalErr != nil) != test.wantErr || got != test.want {
+			t.Errorf("json.Unmarshal(%s) = %q, %v, want %q", test.data, got, unmarshalErr, test.want)
+		}
+	}
+}
+
+func TestGraphQLString(t *testing.T) {
+	tests := map[string]string{
+		"10.0.0.1":          `"10.0.0.1"`,
+		`10.0.0.1") { id }`: `"10.0.0.1\") { id }"`,
+		"a\\b\nc":           `"a\\b\nc"`,
+	}
+	for value, want := range tests {
+		if got := graphQLString(value); got != want {
+			t.Errorf("graphQLString(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
+
+func TestMutateDevicesFallback(t *testing.T) {
+	var client xmcnbiclient.NBIClient
+
+	mockServer := startMockXMC(t)
+	mockServer.RejectRediscover("10.0.0.2", true)
+	initializeClient(&client)
+
+	triggered, failed := mutateDevices(context.Background(), &client, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"})
+	if !reflect.DeepEqual(triggered, []string{"10.0.0.1", "10.0.0.3"}) {
+		t.Errorf("mutateDevices() triggered %v, want [10.0.0.1 10.0.0.3]", triggered)
+	}
+	if len(failed) != 1 || failed["10.0.0.2"] == nil {
+		t.Errorf("mutateDevices() failed for %v, want only 10.0.0.2", failed)
+	}
+	if mockServer.Rediscovers("10.0.0.1") != 1 || mockServer.Rediscovers("10.0.0.2") != 0 {
+		t.Errorf("devices were rediscovered %d and %d time(s), want 1 and 0", mockServer.Rediscovers("10.0.0.1"), mockServer.Rediscovers("10.0.0.2"))
+	}
+}
+
+func TestSendRediscoverMutationRejectedDevice(t *testing.T) {
+	var client xmcnbiclient.NBIClient
+
+	mockServer := startMockXMC(t)
+	mockServer.RejectRediscover("10.0.0.2", true)
+	initializeClient(&client)
+
+	// XMC reports a single status, so one rejected device fails the whole batch
+	if mutationErr := sendRediscoverMutation(context.Background(), &client, []string{"10.0.0.1", "10.0.0.2"}); mutationErr == nil {
+		t.Errorf("sendRediscoverMutation() returned no error for a batch with a rejected device")
+	}
+	if mockServer.Rediscovers("10.0.0.1") != 0 {
+		t.Errorf("10.0.0.1 was rediscovered %d time(s), want 0", mockServer.Rediscovers("10.0.0.1"))
+	}
+	if mutationErr := sendRediscoverMutation(context.Background(), &client, []string{"10.0.0.1", "10.0.0.3"}); mutationErr != nil {
+		t.Errorf("sendRediscoverMutation() returned error: %s", mutationErr)
+	}
+}
+
+func TestMarkRefreshedDevices(t *testing.T) {
+	config = appConfig{RefreshWait: 1}
+	devices := []singleDevice{{IPAddress: "10.0.0.1"}, {IPAddress: "10.0.0.2"}, {IPAddress: "10.0.0.3"}}
+	refresh := refreshOutcome{Triggered: []string{"10.0.0.1", "10.0.0.2"}, Unfinished: []string{"10.0.0.2"}}
+
+	markRefreshedDevices(devices, refresh)
+	for i, want := range []string{refreshFinished, refreshUnfinished, ""} {
+		if devices[i].Refresh != want {
+			t.Errorf("device %s has refresh %q, want %q", devices[i].IPAddress, devices[i].Refresh, want)
+		}
+	}
+
+	config.NoRefresh = true
+	devices[0].Refresh = ""
+	markRefreshedDevices(devices[:1], refresh)
+	if devices[0].Refresh != "" {
+		t.Errorf("device %s was marked without refreshing", devices[0].IPAddress)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+/*
+#### ##     ## ########   #######  ########  ########  ######
+ ##  ###   ### ##     ## ##     ## ##     ##    ##    ##    ##
+ ##  #### #### ##     ## ##     ## ##     ##    ##    ##
+ ##  ## ### ## ########  ##     ## ########     ##     ######
+ ##  ##     ## ##        ##     ## ##   ##      ##          ##
+ ##  ##     ## ##        ##     ## ##    ##     ##    ##    ##
+#### ##     ## ##         #######  ##     ##    ##     ######
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+/*
+ ######   #######  ##    ##  ######  ########    ###    ##    ## ########  ######
+##    ## ##     ## ###   ## ##    ##    ##      ## ##   ###   ##    ##    ##    ##
+##       ##     ## ####  ## ##          ##     ##   ##  ####  ##    ##    ##
+##       ##     ## ## ## ##  ######     ##    ##     ## ## ## ##    ##     ######
+##       ##     ## ##  ####       ##    ##    ######### ##  ####    ##          ##
+##    ## ##     ## ##   ### ##    ##    ##    ##     ## ##   ###    ##    ##    ##
+ ######   #######  ##    ##  ######     ##    ##     ## ##    ##    ##     ######
+*/
+
+var (
+	// Magic bytes that start every gzip compressed file
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+/*
+######## ##     ## ##    ##  ######   ######
+##       ##     ## ###   ## ##    ## ##    ##
+##       ##     ## ####  ## ##       ##
+######   ##     ## ## ## ## ##        ######
+##       ##     ## ##  #### ##             ##
+##       ##     ## ##   ### ##    ## ##    ##
+##        #######  ##    ##  ######   ######
+*/
+
+// Opens a file for reading, transparently decompressing gzip data
+func openInfile(filename string) (io.ReadCloser, error) {
+	fileHandle, fileErr := os.Open(filename)
+	if fileErr != nil {
+		return nil, fmt.Errorf("could not open file: %s", fileErr)
+	}
+
+	fileReader := bufio.NewReader(fileHandle)
+	magic, _ := fileReader.Peek(len(gzipMagic))
+	if !bytes.Equal(magic, gzipMagic) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{fileReader, fileHandle}, nil
+	}
+
+	gzReader, gzErr := gzip.NewReader(fileReader)
+	if gzErr != nil {
+		fileHandle.Close()
+		return nil, fmt.Errorf("could not initialize decompression: %s", gzErr)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gzReader, fileHandle}, nil
+}
+
+// Reads results that were previously written by writeResultsJSON
+func readResultsJSON(filename string) (devicesWrapper, error) {
+	var results devicesWrapper
+
+	infile, infileErr := openInfile(filename)
+	if infileErr != nil {
+		return results, infileErr
+	}
+	defer infile.Close()
+
+	jsonErr := json.NewDecoder(infile).Decode(&results)
+	if jsonErr != nil {
+		return results, fmt.Errorf("could not decode JSON: %s", jsonErr)
+	}
+
+	// Files written before the status was recorded only contain devices that could be queried
+	for i := range results.Devices {
+		if results.Devices[i].Status == "" {
+			results.Devices[i].Status = deviceStatusOK
+			if !results.Devices[i].Up {
+				results.Devices[i].Status = deviceStatusDown
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// Reads a list of IP addresses, one per line or comma-separated; "-" reads from stdin
+func readDeviceList(filename string) ([]string, error) {
+	var deviceIPs []string
+	var infile io.ReadCloser
+	var infileErr error
+
+	if filename == "-" {
+		infile = os.Stdin
+	} else {
+		infile, infileErr = openInfile(filename)
+		if infileErr != nil {
+			return deviceIPs, infileErr
+		}
+		defer infile.Close()
+	}
+
+	knownIPs := make(map[string]bool)
+	lineNumber := 0
+	lineScanner := bufio.NewScanner(infile)
+	for lineScanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(strings.SplitN(lineScanner.Text(), "#", 2)[0])
+		if line == "" {
+			continue
+		}
+		foundIP := false
+		for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ';' || r == ' ' || r == '\t' }) {
+			deviceIP := net.ParseIP(strings.Trim(field, `"'`))
+			if deviceIP == nil {
+				continue
+			}
+			foundIP = true
+			if !knownIPs[deviceIP.String()] {
+				knownIPs[deviceIP.String()] = true
+				deviceIPs = append(deviceIPs, deviceIP.String())
+			}
+		}
+		// The first line may be a CSV header
+		if !foundIP && lineNumber > 1 {
+			stdErr.Printf("Ignoring line %d of <%s>: no IP address found\n", lineNumber, filename)
+		}
+	}
+	if scanErr := lineScanner.Err(); scanErr != nil {
+		return deviceIPs, fmt.Errorf("could not read file: %s", scanErr)
+	}
+
+	return deviceIPs, nil
+}
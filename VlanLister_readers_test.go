@@ -0,0 +1,161 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Writes content to a file in a temporary directory, gzip compressed if compress is set
+func writeTestInfile(t *testing.T, name string, content string, compress bool) string {
+	filename := filepath.Join(t.TempDir(), name)
+	fileHandle, fileErr := os.Create(filename)
+	if fileErr != nil {
+		t.Fatalf("Could not create test file: %s", fileErr)
+	}
+	defer fileHandle.Close()
+
+	if !compress {
+		fileHandle.WriteString(content)
+		return filename
+	}
+	gzWriter := gzip.NewWriter(fileHandle)
+	gzWriter.Write([]byte(content))
+	if gzErr := gzWriter.Close(); gzErr != nil {
+		t.Fatalf("Could not compress test file: %s", gzErr)
+	}
+	return filename
+}
+
+func TestReadResultsJSON(t *testing.T) {
+	snapshot := devicesWrapper{Devices: []singleDevice{
+		{IPAddress: "10.0.0.1", SysName: "core-sw1", Up: true, Status: deviceStatusOK, Vlans: []deviceVlan{{ID: 10, Name: "Data"}}},
+		{IPAddress: "10.0.0.2", Status: deviceStatusFailed, Error: "timeout"},
+	}}
+	snapshotJSON, jsonErr := snapshot.ToJSON()
+	if jsonErr != nil {
+		t.Fatalf("ToJSON() returned error: %s", jsonErr)
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+	}{
+		{"plain", writeTestInfile(t, "snapshot.json", snapshotJSON, false)},
+		{"gzip", writeTestInfile(t, "snapshot.json.gz", snapshotJSON, true)},
+		{"gzip without suffix", writeTestInfile(t, "snapshot.json", snapshotJSON, true)},
+	}
+	for _, test := range tests {
+		results, readErr := readResultsJSON(test.filename)
+		if readErr != nil {
+			t.Errorf("%s: readResultsJSON() returned error: %s", test.name, readErr)
+			continue
+		}
+		if len(results.Devices) != 2 || results.Devices[0].SysName != "core-sw1" || !reflect.DeepEqual(results.Devices[0].Vlans, snapshot.Devices[0].Vlans) {
+			t.Errorf("%s: readResultsJSON() = %+v, want %+v", test.name, results, snapshot)
+		}
+		if results.Devices[1].Status != deviceStatusFailed {
+			t.Errorf("%s: status of 10.0.0.2 is %s, want %s", test.name, results.Devices[1].Status, deviceStatusFailed)
+		}
+	}
+}
+
+func TestReadResultsJSONWithoutStatus(t *testing.T) {
+	filename := writeTestInfile(t, "old.json", `{"devices": [
+		{"ipAddress": "10.0.0.1", "up": true, "vlans": [{"id": 10, "name": "Data"}]},
+		{"ipAddress": "10.0.0.2", "up": false}
+	]}`, false)
+
+	results, readErr := readResultsJSON(filename)
+	if readErr != nil {
+		t.Fatalf("readResultsJSON() returned error: %s", readErr)
+	}
+	for i, want := range []string{deviceStatusOK, deviceStatusDown} {
+		if results.Devices[i].Status != want {
+			t.Errorf("status of %s is %q, want %q", results.Devices[i].IPAddress, results.Devices[i].Status, want)
+		}
+	}
+}
+
+func TestReadResultsJSONInvalid(t *testing.T) {
+	for _, filename := range []string{
+		filepath.Join(t.TempDir(), "missing.json"),
+		writeTestInfile(t, "broken.json", `{"devices": [`, false),
+		writeTestInfile(t, "broken.json.gz", "\x1f\x8b not really gzip", false),
+	} {
+		if _, readErr := readResultsJSON(filename); readErr == nil {
+			t.Errorf("readResultsJSON(%s) returned no error", filename)
+		}
+	}
+}
+
+func TestOpenInfile(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		infile, infileErr := openInfile(writeTestInfile(t, "devices.txt", "10.0.0.1\n", compress))
+		if infileErr != nil {
+			t.Fatalf("openInfile() returned error: %s", infileErr)
+		}
+		content, _ := ioutil.ReadAll(infile)
+		infile.Close()
+		if string(content) != "10.0.0.1\n" {
+			t.Errorf("openInfile() read %q with compression %t, want %q", content, compress, "10.0.0.1\n")
+		}
+	}
+}
+
+func TestReadDeviceList(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"one per line", "10.0.0.1\n10.0.0.2\n", []string{"10.0.0.1", "10.0.0.2"}},
+		{"comma-separated", "10.0.0.1,10.0.0.2; 10.0.0.3", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"comments and blank lines", "# core switches\n\n10.0.0.1 # core-sw1\n   \n#10.0.0.9\n10.0.0.2\n", []string{"10.0.0.1", "10.0.0.2"}},
+		{"surrounding whitespace", "  10.0.0.1\t\n\t10.0.0.2  \r\n", []string{"10.0.0.1", "10.0.0.2"}},
+		{"quoted", "\"10.0.0.1\",'10.0.0.2'\n", []string{"10.0.0.1", "10.0.0.2"}},
+		{"invalid IPs", "10.0.0.1\n10.0.0.256\ncore-sw1\n10.0.0.2\n", []string{"10.0.0.1", "10.0.0.2"}},
+		{"duplicates", "10.0.0.1\n10.0.0.2\n10.0.0.1, 10.0.0.2\n", []string{"10.0.0.1", "10.0.0.2"}},
+		{"IPv6 duplicates", "2001:db8::1\n2001:DB8:0::1\n", []string{"2001:db8::1"}},
+		{"CSV header", "IP,SysName\n10.0.0.1,core-sw1\n", []string{"10.0.0.1"}},
+		{"empty", "", nil},
+	}
+	for _, test := range tests {
+		got, listErr := readDeviceList(writeTestInfile(t, "devices.txt", test.content, false))
+		if listErr != nil {
+			t.Errorf("%s: readDeviceList() returned error: %s", test.name, listErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: readDeviceList() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReadDeviceListStdin(t *testing.T) {
+	stdin, openErr := os.Open(writeTestInfile(t, "stdin.txt", "10.0.0.1\n# comment\n10.0.0.2,10.0.0.1\n", false))
+	if openErr != nil {
+		t.Fatalf("Could not open test file: %s", openErr)
+	}
+	defer stdin.Close()
+	originalStdin := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = originalStdin }()
+
+	got, listErr := readDeviceList("-")
+	if listErr != nil {
+		t.Fatalf("readDeviceList() returned error: %s", listErr)
+	}
+	if want := []string{"10.0.0.1", "10.0.0.2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("readDeviceList() = %v, want %v", got, want)
+	}
+}
+
+func TestReadDeviceListMissingFile(t *testing.T) {
+	if _, listErr := readDeviceList(filepath.Join(t.TempDir(), "missing.txt")); listErr == nil {
+		t.Errorf("readDeviceList() returned no error for a missing file")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+/*
+#### ##     ## ########   #######  ########  ########  ######
+ ##  ###   ### ##     ## ##     ## ##     ##    ##    ##    ##
+ ##  #### #### ##     ## ##     ## ##     ##    ##    ##
+ ##  ## ### ## ########  ##     ## ########     ##     ######
+ ##  ##     ## ##        ##     ## ##   ##      ##          ##
+ ##  ##     ## ##        ##     ## ##    ##     ##    ##    ##
+#### ##     ## ##         #######  ##     ##    ##     ######
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ ######   #######  ##    ##  ######  ########    ###    ##    ## ########  ######
+##    ## ##     ## ###   ## ##    ##    ##      ## ##   ###   ##    ##    ##    ##
+##       ##     ## ####  ## ##          ##     ##   ##  ####  ##    ##    ##
+##       ##     ## ## ## ##  ######     ##    ##     ## ## ## ##    ##     ######
+##       ##     ## ##  ####       ##    ##    ######### ##  ####    ##          ##
+##    ## ##     ## ##   ### ##    ##    ##    ##     ## ##   ###    ##    ##    ##
+ ######   #######  ##    ##  ######     ##    ##     ## ##    ##    ##     ######
+*/
+
+const (
+	// Everything worked
+	exitOK int = 0
+	// Fatal error or not a single device could be queried
+	exitTotalFailure int = 1
+	// Invalid options were given; also used by pflag for unknown options
+	exitInvalidOptions int = 2
+	// Some devices could not be queried
+	exitPartialFailure int = 3
+	// At least one outfile could not be written
+	exitWriteFailure int = 4
+	// The run was interrupted by a signal; outfiles contain partial results
+	exitInterrupted int = 5
+)
+
+/*
+##     ##    ###    ########   ######
+##     ##   ## ##   ##     ## ##    ##
+##     ##  ##   ##  ##     ## ##
+##     ## ##     ## ########   ######
+ ##   ##  ######### ##   ##         ##
+  ## ##   ##     ## ##    ##  ##    ##
+   ###    ##     ## ##     ##  ######
+*/
+
+var (
+	// Statistics of the current run
+	summary = runSummary{Tool: toolID, startTime: time.Now()}
+)
+
+/*
+######## ##    ## ########  ########  ######
+   ##     ##  ##  ##     ## ##       ##    ##
+   ##      ####   ##     ## ##       ##
+   ##       ##    ########  ######    ######
+   ##       ##    ##        ##             ##
+   ##       ##    ##        ##       ##    ##
+   ##       ##    ##        ########  ######
+*/
+
+// Stores the result of writing a single outfile.
+type outfileSummary struct {
+	Outfile string `json:"outfile"`
+	Rows    uint   `json:"rows"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Stores the statistics of a single run.
+type runSummary struct {
+	Tool              string           `json:"tool"`
+	StartedAt         string           `json:"startedAt"`
+	FinishedAt        string           `json:"finishedAt"`
+	DurationSeconds   float64          `json:"durationSeconds"`
+	Infile            string           `json:"infile,omitempty"`
+	DevicesDiscovered int              `json:"devicesDiscovered"`
+	DevicesResumed    int              `json:"devicesResumed"`
+	DevicesRefreshed  int              `json:"devicesRefreshed"`
+	DevicesUnfinished int              `json:"devicesRefreshUnfinished"`
+	UnfinishedDevices []string         `json:"refreshUnfinished,omitempty"`
+	DevicesQueried    int              `json:"devicesQueried"`
+	DevicesDown       int              `json:"devicesDown"`
+	DevicesFailed     int              `json:"devicesFailed"`
+	DevicesSkipped    int              `json:"devicesSkipped"`
+	Retries           uint64           `json:"retries"`
+	Outfiles          []outfileSummary `json:"outfiles"`
+	Error             string           `json:"error,omitempty"`
+	Interrupted       bool             `json:"interrupted"`
+	ExitCode          int              `json:"exitCode"`
+	startTime         time.Time
+}
+
+/*
+######## ##    ## ########  ########    ######## ##     ## ##    ##  ######   ######
+   ##     ##  ##  ##     ## ##          ##       ##     ## ###   ## ##    ## ##    ##
+   ##      ####   ##     ## ##          ##       ##     ## ####  ## ##       ##
+   ##       ##    ########  ######      ######   ##     ## ## ## ## ##        ######
+   ##       ##    ##        ##          ##       ##     ## ##  #### ##             ##
+   ##       ##    ##        ##          ##       ##     ## ##   ### ##    ## ##    ##
+   ##       ##    ##        ########    ##        #######  ##    ##  ######   ######
+*/
+
+// Records the result of writing a single outfile.
+func (rs *runSummary) AddOutfile(outfile string, rows uint, writeErr error) {
+	result := outfileSummary{Outfile: outfile, Rows: rows}
+	if writeErr != nil {
+		result.Error = writeErr.Error()
+	}
+	rs.Outfiles = append(rs.Outfiles, result)
+}
+
+// Completes the statistics based on the final results and determines the exit code.
+func (rs *runSummary) Finish(results []singleDevice) {
+	var devicesOK int
+
+	rs.DevicesDown = 0
+	rs.DevicesFailed = 0
+	rs.DevicesSkipped = 0
+	for _, dev := range results {
+		switch dev.Status {
+		case deviceStatusOK:
+			devicesOK++
+		case deviceStatusDown:
+			rs.DevicesDown++
+		case deviceStatusFailed:
+			rs.DevicesFailed++
+		case deviceStatusSkipped:
+			rs.DevicesSkipped++
+		}
+	}
+	rs.Retries = atomic.LoadUint64(&retryCount)
+
+	finishTime := time.Now()
+	rs.StartedAt = rs.startTime.Format(time.RFC3339)
+	rs.FinishedAt = finishTime.Format(time.RFC3339)
+	rs.DurationSeconds = finishTime.Sub(rs.startTime).Round(time.Millisecond).Seconds()
+
+	var writeFailed bool
+	for _, outfile := range rs.Outfiles {
+		if outfile.Error != "" {
+			writeFailed = true
+		}
+	}
+
+	switch {
+	case rs.Interrupted:
+		rs.ExitCode = exitInterrupted
+	case rs.Error != "":
+		rs.ExitCode = exitTotalFailure
+	case rs.DevicesFailed > 0 && devicesOK == 0:
+		rs.ExitCode = exitTotalFailure
+	case writeFailed:
+		rs.ExitCode = exitWriteFailure
+	case rs.DevicesFailed > 0:
+		rs.ExitCode = exitPartialFailure
+	default:
+		rs.ExitCode = exitOK
+	}
+}
+
+// Transforms a runSummary struct into a string representing JSON output.
+func (rs *runSummary) ToJSON(indent bool) (string, error) {
+	var jsonData []byte
+	var jsonErr error
+
+	if indent {
+		jsonData, jsonErr = json.MarshalIndent(rs, "", "    ")
+	} else {
+		jsonData, jsonErr = json.Marshal(rs)
+	}
+	if jsonErr != nil {
+		return "", fmt.Errorf("Could not encode JSON: %s", jsonErr)
+	}
+	return string(jsonData), nil
+}
+
+/*
+######## ##     ## ##    ##  ######   ######
+##       ##     ## ###   ## ##    ## ##    ##
+##       ##     ## ####  ## ##       ##
+######   ##     ## ## ## ## ##        ######
+##       ##     ## ##  #### ##             ##
+##       ##     ## ##   ### ##    ## ##    ##
+##        #######  ##    ##  ######   ######
+*/
+
+// Writes the run summary to the summary file or, if none was given, as a single line to stderr
+func writeRunSummary() {
+	if config.SummaryFile == "" {
+		jsonData, jsonErr := summary.ToJSON(false)
+		if jsonErr != nil {
+			stdErr.Println(jsonErr)
+			return
+		}
+		fmt.Fprintln(os.Stderr, jsonData)
+		return
+	}
+
+	jsonData, jsonErr := summary.ToJSON(true)
+	if jsonErr != nil {
+		stdErr.Println(jsonErr)
+		return
+	}
+	if _, writeErr := writeLinesToFile(config.SummaryFile, jsonData); writeErr != nil {
+		stdErr.Printf("Could not write summary file <%s>: %s\n", config.SummaryFile, writeErr)
+	}
+}
+
+// Writes the run summary and terminates the app with the exit code determined from it
+func exitWithSummary(results []singleDevice) {
+	summary.Finish(results)
+	writeRunSummary()
+	if summary.ExitCode != exitOK {
+		stdErr.Printf("Finished with exit code %d.\n", summary.ExitCode)
+	}
+	os.Exit(summary.ExitCode)
+}
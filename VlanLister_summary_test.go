@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRunSummaryExitCode(t *testing.T) {
+	okDevice := singleDevice{IPAddress: "192.0.2.1", Up: true, Status: deviceStatusOK}
+	downDevice := singleDevice{IPAddress: "192.0.2.2", Status: deviceStatusDown}
+	failedDevice := singleDevice{IPAddress: "192.0.2.3", Status: deviceStatusFailed}
+
+	tests := []struct {
+		name     string
+		results  []singleDevice
+		fatal    string
+		writeErr error
+		want     int
+	}{
+		{"all devices queried", []singleDevice{okDevice, downDevice}, "", nil, exitOK},
+		{"no devices at all", nil, "", nil, exitOK},
+		{"some devices failed", []singleDevice{okDevice, failedDevice}, "", nil, exitPartialFailure},
+		{"all devices failed", []singleDevice{failedDevice, downDevice}, "", nil, exitTotalFailure},
+		{"fatal error", nil, "Could not fetch device list", nil, exitTotalFailure},
+		{"outfile failed", []singleDevice{okDevice}, "", errors.New("Could not create outfile"), exitWriteFailure},
+		{"outfile and devices failed", []singleDevice{okDevice, failedDevice}, "", errors.New("Could not create outfile"), exitWriteFailure},
+	}
+	for _, test := range tests {
+		rs := runSummary{Error: test.fatal}
+		rs.AddOutfile("test.csv", 1, test.writeErr)
+		rs.Finish(test.results)
+		if rs.ExitCode != test.want {
+			t.Errorf("%s: exit code %d, want %d", test.name, rs.ExitCode, test.want)
+		}
+	}
+}
+
+func TestToSummaryRowsDeviceCounts(t *testing.T) {
+	results := devicesWrapper{Devices: []singleDevice{
+		{IPAddress: "192.0.2.1", Up: true, Status: deviceStatusOK},
+		{IPAddress: "192.0.2.2", Status: deviceStatusDown},
+		{IPAddress: "192.0.2.3", Status: deviceStatusFailed},
+		{IPAddress: "192.0.2.4", Status: deviceStatusFailed},
+		{IPAddress: "192.0.2.5", Status: deviceStatusSkipped},
+	}}
+	want := map[string]int{"Devices": 5, "Devices up": 1, "Devices down": 1, "Devices failed": 2, "Devices skipped": 1}
+
+	for _, row := range results.ToSummaryRows() {
+		if count, found := want[row[0].(string)]; found && row[1] != count {
+			t.Errorf("%s = %v, want %d", row[0], row[1], count)
+		}
+		delete(want, row[0].(string))
+	}
+	for name := range want {
+		t.Errorf("ToSummaryRows() contains no row %s", name)
+	}
+}
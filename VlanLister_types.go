@@ -13,8 +13,10 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 /*
@@ -28,12 +30,23 @@ import (
 */
 
 const (
-	csvFormatString string = `"%s","%s","%s","%s","%s","%s","%s","%s","%s","%s"`
+	// Status of a device that was queried successfully and is up
+	deviceStatusOK string = "ok"
+	// Status of a device that is down in XMC
+	deviceStatusDown string = "down"
+	// Status of a device that could not be queried
+	deviceStatusFailed string = "failed"
+	// Status of a device that was not queried because the run was interrupted
+	deviceStatusSkipped string = "skipped"
+	// Refresh of a device that reported a fresh poll before it was queried
+	refreshFinished string = "finished"
+	// Refresh of a device that did not report a fresh poll within RefreshWait; its data may be outdated
+	refreshUnfinished string = "unfinished"
 )
 
 var (
-	// Columns used in outfiles
-	csvColumns = [...]string{"ID", "BaseMac", "IP", "SysUpDown", "SysName", "SysLocation", "IfName", "IfStatus", "Untagged", "Tagged"}
+	// Default columns used in CSV outfiles
+	csvColumns = [...]string{"ID", "BaseMac", "IP", "SysUpDown", "SysName", "SysLocation", "IfName", "IfStatus", "Untagged", "Tagged", "Status", "Error", "IfAlias", "IfDescr", "IfSpeed", "IfDuplex", "IfType", "Lag", "LagMembers", "Uplink", "Neighbor", "NeighborPort", "NeighborIP", "Refresh"}
 )
 
 /*
@@ -66,12 +79,36 @@ func (oa *stringArray) Type() string {
 	return "string"
 }
 
+// Stores a single row of typed values for tabular output.
+// CSV writers render each value as text, XLSX writers keep numbers as numbers.
+type tableRow []interface{}
+
+// Stores a string that XMC may return as a string or as a number, depending on the version.
+type looseString string
+
+// Accepts JSON strings, numbers and null.
+func (ls *looseString) UnmarshalJSON(data []byte) error {
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		*ls = looseString(text)
+		return nil
+	}
+	var number json.Number
+	if numberErr := json.Unmarshal(data, &number); numberErr != nil {
+		return fmt.Errorf("expected string or number, got %s", data)
+	}
+	*ls = looseString(number.String())
+	return nil
+}
+
 // Stores configuration data used throughout the app.
 type appConfig struct {
 	XMCHost         string
 	XMCPort         uint
 	XMCPath         string
 	HTTPTimeout     uint
+	Retries         uint
+	RetryDelay      uint
 	NoHTTPS         bool
 	InsecureHTTPS   bool
 	BasicAuth       bool
@@ -79,12 +116,33 @@ type appConfig struct {
 	XMCSecret       string
 	XMCQuery        string
 	NoRefresh       bool
+	NoNeighbors     bool
 	RefreshInterval uint
+	RefreshBatch    uint
 	RefreshWait     uint
+	RefreshPoll     uint
+	Workers         uint
 	IncludeDown     bool
+	DevicesFile     string
+	Include         stringArray
+	Exclude         stringArray
 	NoColor         bool
+	Infile          string
+	DiffBaseline    string
+	VlanCatalog     string
 	Outfile         stringArray
+	SummaryFile     string
+	Record          string
+	Replay          string
+	Checkpoint      string
+	Resume          bool
+	Columns         string
+	CSVDelimiter    string
+	CSVBOM          bool
 	CompressOutput  bool
+	LagMode         string
+	ServeMock       string
+	MockFixture     string
 	PrintVersion    bool
 }
 
@@ -93,14 +151,46 @@ type xmcDeviceList struct {
 	Data struct {
 		Network struct {
 			Devices []struct {
-				Up bool   `json:"up"`
-				IP string `json:"ip"`
+				Up                  bool   `json:"up"`
+				IP                  string `json:"ip"`
+				SysName             string `json:"sysName"`
+				SysLocation         string `json:"sysLocation"`
+				SiteName            string `json:"siteName"`
+				DeviceDisplayFamily string `json:"deviceDisplayFamily"`
+				DeviceData          struct {
+					SubFamily string `json:"subFamily"`
+				} `json:"deviceData"`
 			} `json:"devices"`
 		} `json:"network"`
 	} `json:"data"`
 }
 
+// Used for parsing the poll status of all devices returned by XMC.
+type xmcDevicePollList struct {
+	Data struct {
+		Network struct {
+			Devices []struct {
+				IP         string `json:"ip"`
+				LastUpdate int64  `json:"lastUpdate"`
+			} `json:"devices"`
+		} `json:"network"`
+	} `json:"data"`
+}
+
+// Stores the outcome of refreshing a list of devices.
+type refreshOutcome struct {
+	// Devices a rediscover was triggered for
+	Triggered []string
+	// Devices a rediscover could not be triggered for, with the error that occurred
+	Failed map[string]error
+	// Devices that were not refreshed because the run was interrupted
+	Skipped []string
+	// Devices that were triggered but did not report a fresh poll within RefreshWait
+	Unfinished []string
+}
+
 // Used to parse the result returned by XMC for each single mutation (device refresh).
+// The NBI only returns one status and message per mutation, not one per device.
 type xmcMutationMessage struct {
 	Data struct {
 		Network struct {
@@ -126,12 +216,18 @@ type xmcDeviceData struct {
 				IP          string `json:"ip"`
 				EntityData  struct {
 					AllPorts []struct {
-						IfIndex       int      `json:"ifIndex"`
-						IfPhysAddress string   `json:"ifPhysAddress"`
-						IfName        string   `json:"ifName"`
-						IfAdminStatus string   `json:"ifAdminStatus"`
-						IfOperStatus  string   `json:"ifOperStatus"`
-						VlanList      []string `json:"vlanList"`
+						IfIndex       int         `json:"ifIndex"`
+						IfPhysAddress string      `json:"ifPhysAddress"`
+						IfName        string      `json:"ifName"`
+						IfAlias       string      `json:"ifAlias"`
+						IfDescr       string      `json:"ifDescr"`
+						IfAdminStatus string      `json:"ifAdminStatus"`
+						IfOperStatus  string      `json:"ifOperStatus"`
+						IfSpeed       looseString `json:"ifSpeed"`
+						Duplex        looseString `json:"duplex"`
+						IfType        looseString `json:"ifType"`
+						LagIfIndex    int         `json:"lagIfIndex"`
+						VlanList      []string    `json:"vlanList"`
 					} `json:"allPorts"`
 				} `json:"entityData"`
 			} `json:"device"`
@@ -146,6 +242,25 @@ type xmcDeviceData struct {
 	} `json:"data"`
 }
 
+// Used to parse the neighbors returned by XMC for each single device.
+type xmcDeviceNeighbors struct {
+	Data struct {
+		Network struct {
+			DeviceNeighbors []struct {
+				LocalIfIndex  int    `json:"localIfIndex"`
+				LocalPort     string `json:"localPort"`
+				RemoteSysName string `json:"remoteSysName"`
+				RemotePort    string `json:"remotePort"`
+				RemoteIP      string `json:"remoteIp"`
+				Protocol      string `json:"protocol"`
+			} `json:"deviceNeighbors"`
+		} `json:"network"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
 // Stores data related to the VLANs configured on a device.
 type deviceVlan struct {
 	Type      string `json:"type"`
@@ -155,15 +270,36 @@ type deviceVlan struct {
 	Netmask   string `json:"netmask"`
 }
 
+// Stores a neighbor discovered on a port via LLDP or CDP.
+type portNeighbor struct {
+	SysName  string `json:"sysName"`
+	Port     string `json:"port"`
+	IP       string `json:"ip"`
+	Protocol string `json:"protocol"`
+}
+
 // Stores data related to the ports of a device.
 type devicePort struct {
-	Index         int    `json:"index"`
-	MACAddress    string `json:"macAddress"`
-	Name          string `json:"name"`
-	AdminStatus   string `json:"adminStatus"`
-	OperStatus    string `json:"operStatus"`
-	UntaggedVlans []int  `json:"untaggedVlans"`
-	TaggedVlans   []int  `json:"taggedVlans"`
+	Index          int            `json:"index"`
+	MACAddress     string         `json:"macAddress"`
+	Name           string         `json:"name"`
+	Alias          string         `json:"alias"`
+	Description    string         `json:"description"`
+	AdminStatus    string         `json:"adminStatus"`
+	OperStatus     string         `json:"operStatus"`
+	Speed          string         `json:"speed"`
+	Duplex         string         `json:"duplex"`
+	Type           string         `json:"type"`
+	LagIfIndex     int            `json:"lagIfIndex,omitempty"`
+	Lag            string         `json:"lag,omitempty"`
+	IsLag          bool           `json:"isLag,omitempty"`
+	LagMembers     []string       `json:"lagMembers,omitempty"`
+	Uplink         bool           `json:"uplink,omitempty"`
+	Neighbors      []portNeighbor `json:"neighbors,omitempty"`
+	UntaggedVlans  []int          `json:"untaggedVlans"`
+	TaggedVlans    []int          `json:"taggedVlans"`
+	ForbiddenVlans []int          `json:"forbiddenVlans,omitempty"`
+	RawVlans       []string       `json:"rawVlans,omitempty"`
 }
 
 // Stores all data related to a single device.
@@ -171,6 +307,9 @@ type singleDevice struct {
 	ID          int          `json:"id"`
 	QueriedAt   string       `json:"queriedAt"`
 	Up          bool         `json:"up"`
+	Status      string       `json:"status"`
+	Error       string       `json:"error,omitempty"`
+	Refresh     string       `json:"refresh,omitempty"`
 	BaseMAC     string       `json:"baseMac"`
 	IPAddress   string       `json:"ipAddress"`
 	SysName     string       `json:"sysName"`
@@ -182,6 +321,7 @@ type singleDevice struct {
 
 // Stores multiple devices.
 type devicesWrapper struct {
+	Partial bool           `json:"partial,omitempty"`
 	Devices []singleDevice `json:"devices"`
 }
 
@@ -195,54 +335,176 @@ type devicesWrapper struct {
    ##       ##    ##        ########    ##        #######  ##    ##  ######   ######
 */
 
-// Transforms a singleDevice struct into an array of strings representing CSV output.
-func (sd *singleDevice) ToCSVRows() ([]string, error) {
-	var result []string
-	var sysID string
-	var sysUpDown string
-	var systemVlanIDs []string
+// Returns true if VLANs and ports of the device are known.
+func (sd *singleDevice) HasData() bool {
+	return sd.Status != deviceStatusFailed && sd.Status != deviceStatusSkipped
+}
 
-	sysID = strconv.Itoa(sd.ID)
-	sysUpDown = "down"
-	if sd.Up {
-		sysUpDown = "up"
-	}
+// Returns the VLAN with the given ID if it is configured on the device.
+func (sd *singleDevice) FindVlan(vid int) (deviceVlan, bool) {
 	for _, vlan := range sd.Vlans {
-		systemVlanIDs = append(systemVlanIDs, strconv.Itoa(vlan.ID))
-	}
-	result = append(result, fmt.Sprintf(csvFormatString, sysID, sd.BaseMAC, sd.IPAddress, sysUpDown, sd.SysName, sd.SysLocation, "SYSTEM", "N/A", "", strings.Join(systemVlanIDs, ",")))
-	for _, port := range sd.Ports {
-		var portUntaggedVlans []string
-		var portTaggedVlans []string
-		for _, untaggedID := range port.UntaggedVlans {
-			portUntaggedVlans = append(portUntaggedVlans, strconv.Itoa(untaggedID))
+		if vlan.ID == vid {
+			return vlan, true
 		}
-		for _, taggedID := range port.TaggedVlans {
-			portTaggedVlans = append(portTaggedVlans, strconv.Itoa(taggedID))
+	}
+	return deviceVlan{}, false
+}
+
+// Transforms a singleDevice struct into rows of values, one row per port.
+// If includeSystem is set, the rows start with a SYSTEM row that represents the device itself.
+func (sd *singleDevice) ToRows(columns []string, includeSystem bool) []tableRow {
+	var result []tableRow
+
+	renderRow := func(port *devicePort) tableRow {
+		var row tableRow
+		for _, column := range columns {
+			row = append(row, columnRenderers[column](sd, port))
 		}
-		result = append(result, fmt.Sprintf(csvFormatString, sysID, sd.BaseMAC, sd.IPAddress, sysUpDown, sd.SysName, sd.SysLocation, port.Name, port.OperStatus, strings.Join(portUntaggedVlans, ","), strings.Join(portTaggedVlans, ",")))
+		return row
 	}
 
-	return result, nil
+	if includeSystem {
+		result = append(result, renderRow(nil))
+	}
+	for portIndex := range sd.Ports {
+		result = append(result, renderRow(&sd.Ports[portIndex]))
+	}
+
+	return result
 }
 
-// Transforms a devicesWrapper struct into a string representing CSV output.
-func (dw *devicesWrapper) ToCSV() (string, error) {
-	var result []string
+// Transforms a devicesWrapper struct into rows of values, one SYSTEM row per device followed by one row per port.
+func (dw *devicesWrapper) ToTable(columns []string) []tableRow {
+	var result []tableRow
+
+	for _, dev := range dw.Devices {
+		result = append(result, dev.ToRows(columns, true)...)
+	}
 
-	result = append(result, fmt.Sprintf(csvFormatString, csvColumns[0], csvColumns[1], csvColumns[2], csvColumns[3], csvColumns[4], csvColumns[5], csvColumns[6], csvColumns[7], csvColumns[8], csvColumns[9]))
+	return result
+}
 
+// Transforms a devicesWrapper struct into rows for the devices sheet of XLSX output.
+// Also returns the index of the device each row belongs to.
+func (dw *devicesWrapper) ToDeviceRows() ([]tableRow, []int) {
+	var result []tableRow
+	var groups []int
+
+	for devIndex, dev := range dw.Devices {
+		sysUpDown := "down"
+		if dev.Up {
+			sysUpDown = "up"
+		}
+		result = append(result, tableRow{dev.ID, dev.IPAddress, dev.BaseMAC, dev.SysName, dev.SysLocation, dev.NickName, sysUpDown, dev.Status, dev.Error, dev.Refresh, dev.QueriedAt, len(dev.Vlans), len(dev.Ports)})
+		groups = append(groups, devIndex)
+	}
+
+	return result, groups
+}
+
+// Transforms a devicesWrapper struct into rows for the ports sheet of XLSX output.
+// Also returns the index of the device each row belongs to.
+func (dw *devicesWrapper) ToPortRows(columns []string) ([]tableRow, []int) {
+	var result []tableRow
+	var groups []int
+
+	for devIndex, dev := range dw.Devices {
+		for _, row := range dev.ToRows(columns, false) {
+			result = append(result, row)
+			groups = append(groups, devIndex)
+		}
+	}
+
+	return result, groups
+}
+
+// Transforms a devicesWrapper struct into rows for the VLANs sheet of XLSX output.
+// Also returns the index of the device each row belongs to.
+func (dw *devicesWrapper) ToVlanRows() ([]tableRow, []int) {
+	var result []tableRow
+	var groups []int
+
+	for devIndex, dev := range dw.Devices {
+		for _, vlan := range dev.Vlans {
+			result = append(result, tableRow{dev.ID, dev.IPAddress, dev.SysName, vlan.ID, vlan.Name, vlan.Type, vlan.PrimaryIP, vlan.Netmask})
+			groups = append(groups, devIndex)
+		}
+	}
+
+	return result, groups
+}
+
+// Transforms a devicesWrapper struct into rows for the failures sheet of XLSX output.
+// Contains every device that is not in status ok.
+// Also returns the index of the device each row belongs to.
+func (dw *devicesWrapper) ToFailureRows() ([]tableRow, []int) {
+	var result []tableRow
+	var groups []int
+
+	for devIndex, dev := range dw.Devices {
+		if dev.Status == deviceStatusOK {
+			continue
+		}
+		result = append(result, tableRow{dev.IPAddress, dev.SysName, dev.Status, dev.Error, dev.QueriedAt})
+		groups = append(groups, devIndex)
+	}
+
+	return result, groups
+}
+
+// Transforms a devicesWrapper struct into rows for the summary sheet of XLSX output.
+func (dw *devicesWrapper) ToSummaryRows() []tableRow {
+	var devicesUp int
+	var devicesDown int
+	var devicesFailed int
+	var devicesSkipped int
+	var devicesUnfinished int
+	var portCount int
+	var portsUp int
+	var vlanCount int
+
+	distinctVlans := make(map[int]bool)
 	for _, dev := range dw.Devices {
-		csvRows, csvRowsError := dev.ToCSVRows()
-		if csvRowsError != nil {
-			return "", fmt.Errorf("Could not convert device to CSV: %s", csvRowsError)
+		switch dev.Status {
+		case deviceStatusOK:
+			devicesUp++
+		case deviceStatusDown:
+			devicesDown++
+		case deviceStatusFailed:
+			devicesFailed++
+		case deviceStatusSkipped:
+			devicesSkipped++
+		}
+		if dev.Refresh == refreshUnfinished {
+			devicesUnfinished++
 		}
-		for row := range csvRows {
-			result = append(result, csvRows[row])
+		for _, port := range dev.Ports {
+			portCount++
+			if port.OperStatus == "up" {
+				portsUp++
+			}
+		}
+		for _, vlan := range dev.Vlans {
+			vlanCount++
+			distinctVlans[vlan.ID] = true
 		}
 	}
 
-	return strings.Join(result, "\n"), nil
+	return []tableRow{
+		{"Generated by", toolID},
+		{"Generated at", time.Now().Format(time.RFC3339)},
+		{"Devices", len(dw.Devices)},
+		{"Devices up", devicesUp},
+		{"Devices down", devicesDown},
+		{"Devices failed", devicesFailed},
+		{"Devices skipped", devicesSkipped},
+		{"Devices with unfinished refresh", devicesUnfinished},
+		{"Partial results", dw.Partial},
+		{"Ports", portCount},
+		{"Ports up", portsUp},
+		{"VLAN definitions", vlanCount},
+		{"Distinct VLANs", len(distinctVlans)},
+	}
 }
 
 // Transforms a devicesWrapper struct into a string representing JSON output.
@@ -253,3 +515,49 @@ func (dw *devicesWrapper) ToJSON() (string, error) {
 	}
 	return string(json), nil
 }
+
+/*
+######## ##     ## ##    ##  ######   ######
+##       ##     ## ###   ## ##    ## ##    ##
+##       ##     ## ####  ## ##       ##
+######   ##     ## ## ## ## ##        ######
+##       ##     ## ##  #### ##             ##
+##       ##     ## ##   ### ##    ## ##    ##
+##        #######  ##    ##  ######   ######
+*/
+
+// Returns a comma-separated representation of a list of VLAN IDs.
+func joinVlanIDs(vlanIDs []int) string {
+	var result []string
+	for _, vid := range vlanIDs {
+		result = append(result, strconv.Itoa(vid))
+	}
+	return strings.Join(result, ",")
+}
+
+// Sorts devices by their ID and IP address.
+func sortDevices(devices []singleDevice) {
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].ID == devices[j].ID {
+			return devices[i].IPAddress < devices[j].IPAddress
+		}
+		return devices[i].ID < devices[j].ID
+	})
+}
+
+// Returns true if both lists contain the same VLAN IDs, regardless of their order.
+func equalVlanIDs(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]int(nil), a...)
+	sortedB := append([]int(nil), b...)
+	sort.Ints(sortedA)
+	sort.Ints(sortedB)
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,235 @@
+package main
+
+/*
+#### ##     ## ########   #######  ########  ########  ######
+ ##  ###   ### ##     ## ##     ## ##     ##    ##    ##    ##
+ ##  #### #### ##     ## ##     ## ##     ##    ##    ##
+ ##  ## ### ## ########  ##     ## ########     ##     ######
+ ##  ##     ## ##        ##     ## ##   ##      ##          ##
+ ##  ##     ## ##        ##     ## ##    ##     ##    ##    ##
+#### ##     ## ##         #######  ##     ##    ##     ######
+*/
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ ######   #######  ##    ##  ######  ########    ###    ##    ## ########  ######
+##    ## ##     ## ###   ## ##    ##    ##      ## ##   ###   ##    ##    ##    ##
+##       ##     ## ####  ## ##          ##     ##   ##  ####  ##    ##    ##
+##       ##     ## ## ## ##  ######     ##    ##     ## ## ## ##    ##     ######
+##       ##     ## ##  ####       ##    ##    ######### ##  ####    ##          ##
+##    ## ##     ## ##   ### ##    ##    ##    ##     ## ##   ###    ##    ##    ##
+ ######   #######  ##    ##  ######     ##    ##     ## ##    ##    ##     ######
+*/
+
+const (
+	// Lowest and highest valid VLAN ID
+	minVlanID int = 1
+	maxVlanID int = 4095
+)
+
+/*
+##     ##    ###    ########   ######
+##     ##   ## ##   ##     ## ##    ##
+##     ##  ##   ##  ##     ## ##
+##     ## ##     ## ########   ######
+ ##   ##  ######### ##   ##         ##
+  ## ##   ##     ## ##    ##  ##    ##
+   ###    ##     ## ##     ##  ######
+*/
+
+var (
+	// Maps the qualifiers used by the different firmware families to port memberships
+	vlanQualifiers = map[string]vlanMembership{
+		"untagged":  vlanUntagged,
+		"native":    vlanUntagged,
+		"pvid":      vlanUntagged,
+		"access":    vlanUntagged,
+		"tagged":    vlanTagged,
+		"trunk":     vlanTagged,
+		"egress":    vlanEgress,
+		"member":    vlanEgress,
+		"static":    vlanEgress,
+		"forbidden": vlanForbidden,
+	}
+)
+
+/*
+######## ##    ## ########  ########  ######
+   ##     ##  ##  ##     ## ##       ##    ##
+   ##      ####   ##     ## ##       ##
+   ##       ##    ########  ######    ######
+   ##       ##    ##        ##             ##
+   ##       ##    ##        ##       ##    ##
+   ##       ##    ##        ########  ######
+*/
+
+// Flags describing how a port is associated with a VLAN.
+type vlanMembership uint
+
+const (
+	// Frames leave the port without tag
+	vlanUntagged vlanMembership = 1 << iota
+	// Frames leave the port with tag
+	vlanTagged
+	// The port is a member; tagged unless flagged as untagged
+	vlanEgress
+	// The port must never become a member
+	vlanForbidden
+)
+
+// Stores the VLAN associations of a single port as parsed from its vlanList.
+type portVlanList struct {
+	Untagged  []int
+	Tagged    []int
+	Forbidden []int
+	Raw       []string
+}
+
+/*
+######## ##     ## ##    ##  ######   ######
+##       ##     ## ###   ## ##    ## ##    ##
+##       ##     ## ####  ## ##       ##
+######   ##     ## ## ## ## ##        ######
+##       ##     ## ##  #### ##             ##
+##       ##     ## ##   ### ##    ## ##    ##
+##        #######  ##    ##  ######   ######
+*/
+
+// Parses the vlanList of a port as returned by XMC, e.g. ["1[Untagged]", "10-12[Tagged]"].
+// Entries that cannot be parsed unambiguously are kept unchanged in Raw.
+func parseVlanList(vlanList []string) portVlanList {
+	var result portVlanList
+
+	for _, entry := range vlanList {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		vlanIDs, membership, parseErr := parseVlanListEntry(entry)
+		if parseErr != nil {
+			result.Raw = append(result.Raw, entry)
+			continue
+		}
+		switch membership {
+		case vlanUntagged, vlanUntagged | vlanEgress:
+			result.Untagged = append(result.Untagged, vlanIDs...)
+		case vlanTagged, vlanEgress, vlanTagged | vlanEgress:
+			result.Tagged = append(result.Tagged, vlanIDs...)
+		case vlanForbidden:
+			result.Forbidden = append(result.Forbidden, vlanIDs...)
+		default:
+			result.Raw = append(result.Raw, entry)
+		}
+	}
+
+	result.Untagged = uniqueVlanIDs(result.Untagged)
+	result.Tagged = uniqueVlanIDs(result.Tagged)
+	result.Forbidden = uniqueVlanIDs(result.Forbidden)
+	return result
+}
+
+// Parses a single vlanList entry consisting of VLAN IDs and qualifiers, e.g. "10,20-29[Tagged]"
+func parseVlanListEntry(entry string) ([]int, vlanMembership, error) {
+	var membership vlanMembership
+
+	vlanSpec := entry
+	if qualifierStart := strings.IndexAny(entry, "[("); qualifierStart >= 0 {
+		vlanSpec = entry[:qualifierStart]
+		qualifiers := entry[qualifierStart:]
+		// some notations use more than one group of qualifiers, e.g. "10[Egress][Untagged]"
+		for qualifiers != "" {
+			closing := "]"
+			if qualifiers[0] == '(' {
+				closing = ")"
+			}
+			groupEnd := strings.Index(qualifiers, closing)
+			if (qualifiers[0] != '[' && qualifiers[0] != '(') || groupEnd < 0 {
+				return nil, 0, fmt.Errorf("unbalanced qualifiers in <%s>", entry)
+			}
+			for _, qualifier := range strings.FieldsFunc(qualifiers[1:groupEnd], isQualifierSeparator) {
+				flag, known := vlanQualifiers[strings.ToLower(qualifier)]
+				if !known {
+					return nil, 0, fmt.Errorf("unknown qualifier <%s>", qualifier)
+				}
+				membership |= flag
+			}
+			qualifiers = strings.TrimSpace(qualifiers[groupEnd+1:])
+		}
+	}
+	if membership == 0 {
+		return nil, 0, fmt.Errorf("no qualifier in <%s>", entry)
+	}
+
+	vlanIDs, rangeErr := parseVlanRanges(vlanSpec)
+	if rangeErr != nil {
+		return nil, 0, rangeErr
+	}
+	return vlanIDs, membership, nil
+}
+
+// Reports whether a rune separates qualifiers within a group
+func isQualifierSeparator(r rune) bool {
+	return r == ',' || r == ' ' || r == '/' || r == '|' || r == ';'
+}
+
+// Expands a comma-separated list of VLAN IDs and ranges, e.g. "10,20-22"
+func parseVlanRanges(vlanSpec string) ([]int, error) {
+	var vlanIDs []int
+
+	for _, part := range strings.Split(vlanSpec, ",") {
+		part = strings.TrimSpace(part)
+		bounds := strings.SplitN(part, "-", 2)
+		first, firstErr := parseVlanID(bounds[0])
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		last := first
+		if len(bounds) == 2 {
+			var lastErr error
+			if last, lastErr = parseVlanID(bounds[1]); lastErr != nil {
+				return nil, lastErr
+			}
+		}
+		if last < first {
+			return nil, fmt.Errorf("invalid VLAN range <%s>", part)
+		}
+		for vid := first; vid <= last; vid++ {
+			vlanIDs = append(vlanIDs, vid)
+		}
+	}
+
+	return vlanIDs, nil
+}
+
+// Parses a single VLAN ID and checks whether it is valid
+func parseVlanID(value string) (int, error) {
+	vid, convErr := strconv.Atoi(strings.TrimSpace(value))
+	if convErr != nil {
+		return 0, fmt.Errorf("invalid VLAN ID <%s>", value)
+	}
+	if vid < minVlanID || vid > maxVlanID {
+		return 0, fmt.Errorf("VLAN ID %d out of range", vid)
+	}
+	return vid, nil
+}
+
+// Sorts VLAN IDs and removes duplicates
+func uniqueVlanIDs(vlanIDs []int) []int {
+	if len(vlanIDs) == 0 {
+		return nil
+	}
+	sort.Ints(vlanIDs)
+	unique := vlanIDs[:1]
+	for _, vid := range vlanIDs[1:] {
+		if vid != unique[len(unique)-1] {
+			unique = append(unique, vid)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+type vlanListTest struct {
+	name     string
+	vlanList []string
+	want     portVlanList
+}
+
+// Runs table-driven tests against parseVlanList
+func runVlanListTests(t *testing.T, tests []vlanListTest) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := parseVlanList(test.vlanList)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseVlanList(%q) = %+v, want %+v", test.vlanList, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseVlanListEXOS(t *testing.T) {
+	runVlanListTests(t, []vlanListTest{
+		{"access port", []string{"1[Untagged]"}, portVlanList{Untagged: []int{1}}},
+		{"trunk port", []string{"1[Untagged]", "20[Tagged]", "10[Tagged]"}, portVlanList{Untagged: []int{1}, Tagged: []int{10, 20}}},
+		{"tagged range", []string{"100-103[Tagged]"}, portVlanList{Tagged: []int{100, 101, 102, 103}}},
+		{"list and range", []string{"10,20-21[Tagged]", "4095[Untagged]"}, portVlanList{Untagged: []int{4095}, Tagged: []int{10, 20, 21}}},
+		{"duplicates", []string{"10[Tagged]", "10[Tagged]", "9-11[Tagged]"}, portVlanList{Tagged: []int{9, 10, 11}}},
+		{"no VLANs", []string{}, portVlanList{}},
+	})
+}
+
+func TestParseVlanListVOSS(t *testing.T) {
+	runVlanListTests(t, []vlanListTest{
+		{"untagged", []string{"1[Untagged]"}, portVlanList{Untagged: []int{1}}},
+		{"mixed case", []string{"10[TAGGED]", "20[tagged]"}, portVlanList{Tagged: []int{10, 20}}},
+		{"whitespace", []string{" 30 [Tagged] ", ""}, portVlanList{Tagged: []int{30}}},
+		{"unknown qualifier", []string{"10[Tagged]", "4051[Private]"}, portVlanList{Tagged: []int{10}, Raw: []string{"4051[Private]"}}},
+	})
+}
+
+func TestParseVlanListERS(t *testing.T) {
+	runVlanListTests(t, []vlanListTest{
+		{"egress untagged", []string{"1[Egress Untagged]"}, portVlanList{Untagged: []int{1}}},
+		{"egress only", []string{"20[Egress]"}, portVlanList{Tagged: []int{20}}},
+		{"separate groups", []string{"1[Egress][Untagged]", "30[Egress][Tagged]"}, portVlanList{Untagged: []int{1}, Tagged: []int{30}}},
+		{"forbidden", []string{"1[Untagged]", "99[Forbidden]"}, portVlanList{Untagged: []int{1}, Forbidden: []int{99}}},
+		{"conflicting qualifiers", []string{"10[Tagged,Untagged]", "20[Forbidden,Tagged]"}, portVlanList{Raw: []string{"10[Tagged,Untagged]", "20[Forbidden,Tagged]"}}},
+	})
+}
+
+func TestParseVlanListCisco(t *testing.T) {
+	runVlanListTests(t, []vlanListTest{
+		{"native VLAN", []string{"1(Native)", "10-11(Trunk)"}, portVlanList{Untagged: []int{1}, Tagged: []int{10, 11}}},
+		{"access VLAN", []string{"20[Access]"}, portVlanList{Untagged: []int{20}}},
+		{"allowed list", []string{"10,12,14-15[Tagged]"}, portVlanList{Tagged: []int{10, 12, 14, 15}}},
+		{"all VLANs", []string{"1-4094[Tagged]"}, portVlanList{Tagged: testVlanRange(1, 4094)}},
+	})
+}
+
+func TestParseVlanListInvalid(t *testing.T) {
+	runVlanListTests(t, []vlanListTest{
+		{"no qualifier", []string{"10"}, portVlanList{Raw: []string{"10"}}},
+		{"no VLAN ID", []string{"[Tagged]"}, portVlanList{Raw: []string{"[Tagged]"}}},
+		{"VLAN ID out of range", []string{"0[Untagged]", "4096[Tagged]"}, portVlanList{Raw: []string{"0[Untagged]", "4096[Tagged]"}}},
+		{"reversed range", []string{"20-10[Tagged]"}, portVlanList{Raw: []string{"20-10[Tagged]"}}},
+		{"unbalanced brackets", []string{"10[Tagged"}, portVlanList{Raw: []string{"10[Tagged"}}},
+		{"garbage", []string{"VLAN10[Tagged]", "10[Tagged]x"}, portVlanList{Raw: []string{"VLAN10[Tagged]", "10[Tagged]x"}}},
+	})
+}
+
+// Returns all VLAN IDs from first to last
+func testVlanRange(first int, last int) []int {
+	var vlanIDs []int
+	for vid := first; vid <= last; vid++ {
+		vlanIDs = append(vlanIDs, vid)
+	}
+	return vlanIDs
+}
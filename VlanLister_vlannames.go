@@ -0,0 +1,301 @@
+package main
+
+/*
+#### ##     ## ########   #######  ########  ########  ######
+ ##  ###   ### ##     ## ##     ## ##     ##    ##    ##    ##
+ ##  #### #### ##     ## ##     ## ##     ##    ##    ##
+ ##  ## ### ## ########  ##     ## ########     ##     ######
+ ##  ##     ## ##        ##     ## ##   ##      ##          ##
+ ##  ##     ## ##        ##     ## ##    ##     ##    ##    ##
+#### ##     ## ##         #######  ##     ##    ##     ######
+*/
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+/*
+##     ##    ###    ########   ######
+##     ##   ## ##   ##     ## ##    ##
+##     ##  ##   ##  ##     ## ##
+##     ## ##     ## ########   ######
+ ##   ##  ######### ##   ##         ##
+  ## ##   ##     ## ##    ##  ##    ##
+   ###    ##     ## ##     ##  ######
+*/
+
+var (
+	// Reference VLAN names keyed by VLAN ID; nil if no catalog is used
+	vlanCatalog map[int]string
+	// Columns used for VLAN name outfiles
+	vlanNameColumns = [...]string{"VlanID", "Name", "Devices", "CatalogName", "Issue"}
+	// Delimiters accepted in CSV catalogs, in the order they are looked for
+	vlanCatalogDelimiters = [...]rune{',', ';', '\t'}
+	// Names of the ID column that mark the first line of a CSV catalog as header, compared case-insensitively
+	vlanCatalogIDColumns = [...]string{"id", "vid", "vlan", "vlanid", "vlan id", "vlan_id"}
+)
+
+/*
+######## ##    ## ########  ########  ######
+   ##     ##  ##  ##     ## ##       ##    ##
+   ##      ####   ##     ## ##       ##
+   ##       ##    ########  ######    ######
+   ##       ##    ##        ##             ##
+   ##       ##    ##        ##       ##    ##
+   ##       ##    ##        ########  ######
+*/
+
+// Stores one name used for a VLAN and the devices that use it.
+type vlanNameUsage struct {
+	Name    string   `json:"name"`
+	Devices []string `json:"devices"`
+	Issues  []string `json:"issues,omitempty"`
+}
+
+// Stores the result of comparing the names of a VLAN across all devices.
+type auditedVlanName struct {
+	ID          int             `json:"id"`
+	Names       []vlanNameUsage `json:"names"`
+	CatalogName string          `json:"catalogName,omitempty"`
+	InCatalog   bool            `json:"inCatalog"`
+	Issues      []string        `json:"issues"`
+	Consistent  bool            `json:"consistent"`
+}
+
+// Stores the name audit results of all VLANs.
+type vlanNameAudit struct {
+	CatalogUsed bool              `json:"catalogUsed"`
+	Vlans       []auditedVlanName `json:"vlans"`
+}
+
+/*
+######## ##    ## ########  ########    ######## ##     ## ##    ##  ######   ######
+   ##     ##  ##  ##     ## ##          ##       ##     ## ###   ## ##    ## ##    ##
+   ##      ####   ##     ## ##          ##       ##     ## ####  ## ##       ##
+   ##       ##    ########  ######      ######   ##     ## ## ## ## ##        ######
+   ##       ##    ##        ##          ##       ##     ## ##  #### ##             ##
+   ##       ##    ##        ##          ##       ##     ## ##   ### ##    ## ##    ##
+   ##       ##    ##        ########    ##        #######  ##    ##  ######   ######
+*/
+
+// Transforms a vlanNameAudit struct into rows of values, one row per VLAN name with issues.
+func (vna *vlanNameAudit) ToRows() []tableRow {
+	var result []tableRow
+
+	for _, vlan := range vna.Vlans {
+		for _, usage := range vlan.Names {
+			if len(usage.Issues) == 0 {
+				continue
+			}
+			result = append(result, tableRow{vlan.ID, usage.Name, strings.Join(usage.Devices, ", "), vlan.CatalogName, strings.Join(usage.Issues, "; ")})
+		}
+	}
+
+	return result
+}
+
+// Transforms a vlanNameAudit struct into a string representing JSON output.
+func (vna *vlanNameAudit) ToJSON() (string, error) {
+	json, jsonErr := json.MarshalIndent(vna, "", "    ")
+	if jsonErr != nil {
+		return "", fmt.Errorf("Could not encode JSON: %s", jsonErr)
+	}
+	return string(json), nil
+}
+
+/*
+######## ##     ## ##    ##  ######   ######
+##       ##     ## ###   ## ##    ## ##    ##
+##       ##     ## ####  ## ##       ##
+######   ##     ## ## ## ## ##        ######
+##       ##     ## ##  #### ##             ##
+##       ##     ## ##   ### ##    ## ##    ##
+##        #######  ##    ##  ######   ######
+*/
+
+// Reads a VLAN catalog mapping VLAN IDs to names; the format is chosen by the suffix (.yaml, .yml or .csv)
+func readVlanCatalog(filename string) (map[int]string, error) {
+	infile, infileErr := openInfile(filename)
+	if infileErr != nil {
+		return nil, infileErr
+	}
+	defer infile.Close()
+
+	switch strings.ToLower(filepath.Ext(strings.TrimSuffix(filename, ".gz"))) {
+	case ".yaml", ".yml":
+		return parseVlanCatalogYAML(infile)
+	case ".csv":
+		return parseVlanCatalogCSV(infile)
+	}
+	return nil, fmt.Errorf("Unknown catalog format, use .yaml, .yml or .csv")
+}
+
+// Parses a VLAN catalog given as a YAML mapping of VLAN IDs to names, e.g. "10: Data"
+func parseVlanCatalogYAML(r io.Reader) (map[int]string, error) {
+	var entries map[int]string
+
+	data, readErr := ioutil.ReadAll(r)
+	if readErr != nil {
+		return nil, fmt.Errorf("Could not read catalog: %s", readErr)
+	}
+	if yamlErr := yaml.UnmarshalStrict(data, &entries); yamlErr != nil {
+		return nil, fmt.Errorf("Could not decode YAML: %s", yamlErr)
+	}
+
+	catalog := make(map[int]string)
+	for vid, name := range entries {
+		if vid < minVlanID || vid > maxVlanID {
+			return nil, fmt.Errorf("VLAN ID %d is out of range", vid)
+		}
+		catalog[vid] = strings.TrimSpace(name)
+	}
+	return catalog, nil
+}
+
+// Returns the delimiter of a CSV catalog: the first of vlanCatalogDelimiters found in the first line that is no comment
+func vlanCatalogDelimiter(text string) rune {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, delimiter := range vlanCatalogDelimiters {
+			if strings.ContainsRune(line, delimiter) {
+				return delimiter
+			}
+		}
+		break
+	}
+	return vlanCatalogDelimiters[0]
+}
+
+// Reports whether a CSV record is a header, i.e. its first field names an ID column
+func isVlanCatalogHeader(record []string) bool {
+	for _, column := range vlanCatalogIDColumns {
+		if strings.EqualFold(strings.TrimSpace(record[0]), column) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses a VLAN catalog given as CSV with the columns ID and name.
+// Fields may be separated by commas, semicolons or tabs; a header line and lines starting with # are ignored.
+func parseVlanCatalogCSV(r io.Reader) (map[int]string, error) {
+	data, readErr := ioutil.ReadAll(r)
+	if readErr != nil {
+		return nil, fmt.Errorf("Could not read catalog: %s", readErr)
+	}
+	text := strings.TrimPrefix(string(data), "\uFEFF")
+
+	csvReader := csv.NewReader(strings.NewReader(text))
+	csvReader.Comma = vlanCatalogDelimiter(text)
+	csvReader.Comment = '#'
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	catalog := make(map[int]string)
+	for recordIndex := 0; ; recordIndex++ {
+		record, csvErr := csvReader.Read()
+		if csvErr == io.EOF {
+			break
+		}
+		if csvErr != nil {
+			return nil, fmt.Errorf("Could not decode CSV: %s", csvErr)
+		}
+		if recordIndex == 0 && isVlanCatalogHeader(record) {
+			continue
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("Entry %d does not contain ID and name", recordIndex+1)
+		}
+		vid, vidErr := strconv.Atoi(strings.TrimSpace(record[0]))
+		if vidErr != nil {
+			return nil, fmt.Errorf("Invalid VLAN ID <%s>", record[0])
+		}
+		if vid < minVlanID || vid > maxVlanID {
+			return nil, fmt.Errorf("VLAN ID %d is out of range", vid)
+		}
+		catalog[vid] = strings.TrimSpace(record[1])
+	}
+	return catalog, nil
+}
+
+// Groups the VLANs of all devices by ID and compares their names with each other and, if given, with the catalog.
+// A VLAN is reported if it is known by different names, lacks a name on some devices or does not match the catalog.
+func auditVlanNames(results devicesWrapper, catalog map[int]string) vlanNameAudit {
+	result := vlanNameAudit{CatalogUsed: catalog != nil}
+
+	usages := make(map[int]map[string][]string)
+	for _, dev := range results.Devices {
+		if !dev.HasData() {
+			continue
+		}
+		deviceName := dev.SysName
+		if deviceName == "" {
+			deviceName = dev.IPAddress
+		}
+		for _, vlan := range dev.Vlans {
+			if usages[vlan.ID] == nil {
+				usages[vlan.ID] = make(map[string][]string)
+			}
+			name := strings.TrimSpace(vlan.Name)
+			usages[vlan.ID][name] = append(usages[vlan.ID][name], deviceName)
+		}
+	}
+
+	for vid, names := range usages {
+		vlan := auditedVlanName{ID: vid, Issues: []string{}}
+		vlan.CatalogName, vlan.InCatalog = catalog[vid]
+
+		namedCount := 0
+		for name := range names {
+			if name != "" {
+				namedCount++
+			}
+		}
+		for name, devices := range names {
+			usage := vlanNameUsage{Name: name, Devices: devices}
+			sort.Strings(usage.Devices)
+			if name == "" && (namedCount > 0 || vlan.CatalogName != "") {
+				usage.Issues = append(usage.Issues, "missing name")
+			}
+			if name != "" && namedCount > 1 {
+				usage.Issues = append(usage.Issues, "conflicting names")
+			}
+			if catalog != nil && !vlan.InCatalog {
+				usage.Issues = append(usage.Issues, "not in catalog")
+			}
+			if vlan.InCatalog && name != "" && name != vlan.CatalogName {
+				usage.Issues = append(usage.Issues, "differs from catalog")
+			}
+			vlan.Names = append(vlan.Names, usage)
+		}
+		sort.Slice(vlan.Names, func(i, j int) bool { return vlan.Names[i].Name < vlan.Names[j].Name })
+
+		seenIssues := make(map[string]bool)
+		for _, usage := range vlan.Names {
+			for _, issue := range usage.Issues {
+				if !seenIssues[issue] {
+					seenIssues[issue] = true
+					vlan.Issues = append(vlan.Issues, issue)
+				}
+			}
+		}
+		vlan.Consistent = len(vlan.Issues) == 0
+		result.Vlans = append(result.Vlans, vlan)
+	}
+	sort.Slice(result.Vlans, func(i, j int) bool { return result.Vlans[i].ID < result.Vlans[j].ID })
+
+	return result
+}
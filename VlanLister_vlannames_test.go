@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Returns three switches that disagree on the names of VLANs 20 and 30
+func testVlanNameResults() devicesWrapper {
+	return devicesWrapper{Devices: []singleDevice{
+		{IPAddress: "10.0.0.1", SysName: "core-sw1", Status: deviceStatusOK, Vlans: []deviceVlan{
+			{ID: 10, Name: "Data"}, {ID: 20, Name: "Voice"}, {ID: 30, Name: "Guest"},
+		}},
+		{IPAddress: "10.0.0.2", SysName: "access-sw1", Status: deviceStatusOK, Vlans: []deviceVlan{
+			{ID: 10, Name: "Data"}, {ID: 20, Name: "VOIP"}, {ID: 30, Name: ""},
+		}},
+		{IPAddress: "10.0.0.3", Status: deviceStatusOK, Vlans: []deviceVlan{
+			{ID: 10, Name: "Data "}, {ID: 20, Name: "Voice"},
+		}},
+		{IPAddress: "10.1.0.1", SysName: "branch-sw1", Status: deviceStatusFailed, Vlans: []deviceVlan{
+			{ID: 10, Name: "Branch"},
+		}},
+	}}
+}
+
+func TestAuditVlanNames(t *testing.T) {
+	audit := auditVlanNames(testVlanNameResults(), nil)
+
+	if len(audit.Vlans) != 3 || audit.CatalogUsed {
+		t.Fatalf("auditVlanNames() returned %+v, want 3 VLANs without catalog", audit)
+	}
+	if !audit.Vlans[0].Consistent {
+		t.Errorf("VLAN 10 is %+v, want consistent", audit.Vlans[0])
+	}
+	if vlan := audit.Vlans[1]; vlan.Consistent || !reflect.DeepEqual(vlan.Issues, []string{"conflicting names"}) {
+		t.Errorf("VLAN 20 has issues %v, want conflicting names", vlan.Issues)
+	}
+	if devices := audit.Vlans[1].Names[1].Devices; !reflect.DeepEqual(devices, []string{"10.0.0.3", "core-sw1"}) {
+		t.Errorf("VLAN 20 is called Voice on %v, want 10.0.0.3 and core-sw1", devices)
+	}
+	if vlan := audit.Vlans[2]; !reflect.DeepEqual(vlan.Issues, []string{"missing name"}) {
+		t.Errorf("VLAN 30 has issues %v, want missing name", vlan.Issues)
+	}
+
+	rows := audit.ToRows()
+	if len(rows) != 3 {
+		t.Fatalf("ToRows() returned %d row(s), want 3: %v", len(rows), rows)
+	}
+	if !reflect.DeepEqual(rows[2], tableRow{30, "", "access-sw1", "", "missing name"}) {
+		t.Errorf("ToRows() returned %v for VLAN 30", rows[2])
+	}
+}
+
+func TestAuditVlanNamesCatalog(t *testing.T) {
+	audit := auditVlanNames(testVlanNameResults(), map[int]string{10: "Data", 20: "Voice"})
+
+	if !audit.Vlans[0].Consistent {
+		t.Errorf("VLAN 10 is %+v, want consistent", audit.Vlans[0])
+	}
+	for _, usage := range audit.Vlans[1].Names {
+		wantIssues := []string{"conflicting names"}
+		if usage.Name == "VOIP" {
+			wantIssues = append(wantIssues, "differs from catalog")
+		}
+		if !reflect.DeepEqual(usage.Issues, wantIssues) {
+			t.Errorf("name %s of VLAN 20 has issues %v, want %v", usage.Name, usage.Issues, wantIssues)
+		}
+	}
+	if vlan := audit.Vlans[2]; vlan.InCatalog || !reflect.DeepEqual(vlan.Issues, []string{"missing name", "not in catalog"}) {
+		t.Errorf("VLAN 30 has issues %v, want missing name and not in catalog", vlan.Issues)
+	}
+}
+
+func TestReadVlanCatalog(t *testing.T) {
+	want := map[int]string{10: "Data", 20: "Voice", 99: "1234"}
+	tests := map[string]string{
+		"catalog.yaml":     "10: Data\n20: \"Voice\"\n99: 1234\n",
+		"catalog.csv":      "ID,Name\n10,Data\n# VoIP\n20, Voice\n99,1234\n",
+		"catalog-semi.csv": "\uFEFF10;Data\n20;Voice\n99;1234\n",
+		"comment.csv":      "# Exported from IPAM, 2026-10-16\nVLAN ID;Name\n10;Data\n20;Voice\n99;1234\n",
+		"semi-tab.csv":     "VID;Name\tDescription\n10;Data\n20;Voice\n99;1234\n",
+		"tab.csv":          "vlan_id\tName\n10\tData\n20\tVoice\n99\t1234\n",
+	}
+	for name, content := range tests {
+		filename := filepath.Join(t.TempDir(), name)
+		if writeErr := os.WriteFile(filename, []byte(content), 0644); writeErr != nil {
+			t.Fatalf("Could not write catalog: %s", writeErr)
+		}
+		catalog, catalogErr := readVlanCatalog(filename)
+		if catalogErr != nil || !reflect.DeepEqual(catalog, want) {
+			t.Errorf("readVlanCatalog(%s) = %v, %v, want %v", name, catalog, catalogErr, want)
+		}
+	}
+
+	invalid := map[string]string{
+		"range.yaml":   "4096: Invalid\n",
+		"syntax.yaml":  "- Data\n",
+		"id.csv":       "10,Data\nVoice,20\n",
+		"header.csv":   "Name,ID\nData,10\n",
+		"columns.csv":  "10,Data\n20\n",
+		"catalog.json": "{}",
+	}
+	for name, content := range invalid {
+		filename := filepath.Join(t.TempDir(), name)
+		if writeErr := os.WriteFile(filename, []byte(content), 0644); writeErr != nil {
+			t.Fatalf("Could not write catalog: %s", writeErr)
+		}
+		if _, catalogErr := readVlanCatalog(filename); catalogErr == nil {
+			t.Errorf("readVlanCatalog(%s) returned no error", name)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+/*
+#### ##     ## ########   #######  ########  ########  ######
+ ##  ###   ### ##     ## ##     ## ##     ##    ##    ##    ##
+ ##  #### #### ##     ## ##     ## ##     ##    ##    ##
+ ##  ## ### ## ########  ##     ## ########     ##     ######
+ ##  ##     ## ##        ##     ## ##   ##      ##          ##
+ ##  ##     ## ##        ##     ## ##    ##     ##    ##    ##
+#### ##     ## ##         #######  ##     ##    ##     ######
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// Columns used in VLAN-centric outfiles
+	vlanColumns = [...]string{"VlanID", "Names", "Devices", "UntaggedPorts", "TaggedPorts", "Ports"}
+)
+
+/*
+######## ##    ## ########  ########  ######
+   ##     ##  ##  ##     ## ##       ##    ##
+   ##      ####   ##     ## ##       ##
+   ##       ##    ########  ######    ######
+   ##       ##    ##        ##             ##
+   ##       ##    ##        ##       ##    ##
+   ##       ##    ##        ########  ######
+*/
+
+// Identifies a port of a device in VLAN-centric outfiles.
+type vlanPort struct {
+	IPAddress string `json:"ipAddress"`
+	Port      string `json:"port"`
+}
+
+// Stores where a single VLAN is present throughout the network.
+type vlanUsage struct {
+	ID            int        `json:"id"`
+	Names         []string   `json:"names"`
+	Devices       []string   `json:"devices"`
+	UntaggedPorts int        `json:"untaggedPorts"`
+	TaggedPorts   int        `json:"taggedPorts"`
+	Ports         []vlanPort `json:"ports"`
+}
+
+// Stores multiple VLANs.
+type vlanUsageWrapper struct {
+	Vlans []vlanUsage `json:"vlans"`
+}
+
+/*
+######## ##    ## ########  ########    ######## ##     ## ##    ##  ######   ######
+   ##     ##  ##  ##     ## ##          ##       ##     ## ###   ## ##    ## ##    ##
+   ##      ####   ##     ## ##          ##       ##     ## ####  ## ##       ##
+   ##       ##    ########  ######      ######   ##     ## ## ## ## ##        ######
+   ##       ##    ##        ##          ##       ##     ## ##  #### ##             ##
+   ##       ##    ##        ##          ##       ##     ## ##   ### ##    ## ##    ##
+   ##       ##    ##        ########    ##        #######  ##    ##  ######   ######
+*/
+
+// Returns a representation of the port as IP|PORT; the IP never contains |, so it can be split off reliably.
+func (vp vlanPort) String() string {
+	return fmt.Sprintf("%s|%s", vp.IPAddress, vp.Port)
+}
+
+// Transforms a vlanUsageWrapper struct into rows of values, one row per VLAN.
+func (vw *vlanUsageWrapper) ToRows() []tableRow {
+	var result []tableRow
+
+	for _, vlan := range vw.Vlans {
+		var ports []string
+		for _, port := range vlan.Ports {
+			ports = append(ports, port.String())
+		}
+		result = append(result, tableRow{vlan.ID, strings.Join(vlan.Names, ","), strings.Join(vlan.Devices, ","), vlan.UntaggedPorts, vlan.TaggedPorts, strings.Join(ports, ",")})
+	}
+
+	return result
+}
+
+// Transforms a vlanUsageWrapper struct into a string representing JSON output.
+func (vw *vlanUsageWrapper) ToJSON() (string, error) {
+	json, jsonErr := json.MarshalIndent(vw, "", "    ")
+	if jsonErr != nil {
+		return "", fmt.Errorf("Could not encode JSON: %s", jsonErr)
+	}
+	return string(json), nil
+}
+
+/*
+######## ##     ## ##    ##  ######   ######
+##       ##     ## ###   ## ##    ## ##    ##
+##       ##     ## ####  ## ##       ##
+######   ##     ## ## ## ## ##        ######
+##       ##     ## ##  #### ##             ##
+##       ##     ## ##   ### ##    ## ##    ##
+##        #######  ##    ##  ######   ######
+*/
+
+// Appends value to list unless it is already contained
+func appendUnique(list []string, value string) []string {
+	for _, element := range list {
+		if element == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// Pivots device-centric results into a VLAN-centric view
+func pivotVlans(results devicesWrapper) vlanUsageWrapper {
+	var result vlanUsageWrapper
+
+	usage := make(map[int]*vlanUsage)
+	getUsage := func(vid int) *vlanUsage {
+		if _, found := usage[vid]; !found {
+			usage[vid] = &vlanUsage{ID: vid}
+		}
+		return usage[vid]
+	}
+
+	for _, dev := range results.Devices {
+		for _, vlan := range dev.Vlans {
+			vlanResult := getUsage(vlan.ID)
+			if vlan.Name != "" {
+				vlanResult.Names = appendUnique(vlanResult.Names, vlan.Name)
+			}
+			// Devices are processed one after another, so duplicates can only be the last element
+			if len(vlanResult.Devices) == 0 || vlanResult.Devices[len(vlanResult.Devices)-1] != dev.IPAddress {
+				vlanResult.Devices = append(vlanResult.Devices, dev.IPAddress)
+			}
+		}
+		for _, port := range dev.Ports {
+			portRef := vlanPort{IPAddress: dev.IPAddress, Port: port.Name}
+			portVlans := make(map[int]bool)
+			for _, vid := range port.UntaggedVlans {
+				vlanResult := getUsage(vid)
+				vlanResult.UntaggedPorts++
+				if !portVlans[vid] {
+					vlanResult.Ports = append(vlanResult.Ports, portRef)
+					portVlans[vid] = true
+				}
+			}
+			for _, vid := range port.TaggedVlans {
+				vlanResult := getUsage(vid)
+				vlanResult.TaggedPorts++
+				if !portVlans[vid] {
+					vlanResult.Ports = append(vlanResult.Ports, portRef)
+					portVlans[vid] = true
+				}
+			}
+		}
+	}
+
+	for _, vlanResult := range usage {
+		sort.Strings(vlanResult.Names)
+		result.Vlans = append(result.Vlans, *vlanResult)
+	}
+	sort.Slice(result.Vlans, func(i, j int) bool { return result.Vlans[i].ID < result.Vlans[j].ID })
+
+	return result
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPivotVlans(t *testing.T) {
+	results := devicesWrapper{Devices: []singleDevice{
+		{IPAddress: "10.0.0.1", Status: deviceStatusOK, Vlans: []deviceVlan{{ID: 1, Name: "Default"}, {ID: 10, Name: "Data"}},
+			Ports: []devicePort{
+				{Name: "1:1", UntaggedVlans: []int{1}, TaggedVlans: []int{10}},
+				{Name: "1:2", UntaggedVlans: []int{10}, TaggedVlans: []int{10}},
+			}},
+		{IPAddress: "2001:db8::2", Status: deviceStatusOK, Vlans: []deviceVlan{{ID: 10, Name: "DATA"}, {ID: 20}},
+			Ports: []devicePort{
+				{Name: "1/1", TaggedVlans: []int{10, 20}},
+			}},
+	}}
+	want := vlanUsageWrapper{Vlans: []vlanUsage{
+		{ID: 1, Names: []string{"Default"}, Devices: []string{"10.0.0.1"}, UntaggedPorts: 1,
+			Ports: []vlanPort{{"10.0.0.1", "1:1"}}},
+		{ID: 10, Names: []string{"DATA", "Data"}, Devices: []string{"10.0.0.1", "2001:db8::2"}, UntaggedPorts: 1, TaggedPorts: 3,
+			Ports: []vlanPort{{"10.0.0.1", "1:1"}, {"10.0.0.1", "1:2"}, {"2001:db8::2", "1/1"}}},
+		{ID: 20, Devices: []string{"2001:db8::2"}, TaggedPorts: 1,
+			Ports: []vlanPort{{"2001:db8::2", "1/1"}}},
+	}}
+
+	got := pivotVlans(results)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("pivotVlans() = %+v, want %+v", got, want)
+	}
+
+	rows := got.ToRows()
+	if wantRow := (tableRow{10, "DATA,Data", "10.0.0.1,2001:db8::2", 1, 3, "10.0.0.1|1:1,10.0.0.1|1:2,2001:db8::2|1/1"}); !reflect.DeepEqual(rows[1], wantRow) {
+		t.Errorf("ToRows() returned %v for VLAN 10, want %v", rows[1], wantRow)
+	}
+}
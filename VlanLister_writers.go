@@ -13,14 +13,32 @@ package main
 import (
 	"bufio"
 	"compress/gzip"
+	"encoding/csv"
 	"fmt"
+	"io"
 	"os"
 	"strings"
-	"time"
+	"text/tabwriter"
+	"unicode/utf8"
 
 	excelize "github.com/360EntSecGroup-Skylar/excelize/v2"
 )
 
+/*
+ ######   #######  ##    ##  ######  ########    ###    ##    ## ########  ######
+##    ## ##     ## ###   ## ##    ##    ##      ## ##   ###   ##    ##    ##    ##
+##       ##     ## ####  ## ##          ##     ##   ##  ####  ##    ##    ##
+##       ##     ## ## ## ##  ######     ##    ##     ## ## ## ##    ##     ######
+##       ##     ## ##  ####       ##    ##    ######### ##  ####    ##          ##
+##    ## ##     ## ##   ### ##    ##    ##    ##     ## ##   ###    ##    ##    ##
+ ######   #######  ##    ##  ######     ##    ##     ## ##    ##    ##     ######
+*/
+
+const (
+	// Maximum width of XLSX columns in characters
+	xlsxMaxColWidth int = 60
+)
+
 /*
 ##     ##    ###    ########   ######
 ##     ##   ## ##   ##     ## ##    ##
@@ -32,8 +50,14 @@ import (
 */
 
 var (
+	// Columns used in the sheets of XLSX outfiles; the ports sheet uses xlsxPortColumns by default
+	xlsxDeviceColumns  = [...]string{"ID", "IP", "BaseMac", "SysName", "SysLocation", "NickName", "SysUpDown", "Status", "Error", "Refresh", "QueriedAt", "Vlans", "Ports"}
+	xlsxPortColumns    = [...]string{"ID", "IP", "SysName", "IfIndex", "IfName", "Lag", "LagMembers", "IfAlias", "IfDescr", "IfMAC", "IfType", "IfAdminStatus", "IfStatus", "IfSpeed", "IfDuplex", "Uplink", "Neighbor", "NeighborPort", "NeighborIP", "Untagged", "Tagged"}
+	xlsxVlanColumns    = [...]string{"ID", "IP", "SysName", "VlanID", "VlanName", "VlanType", "PrimaryIP", "Netmask"}
+	xlsxFailureColumns = [...]string{"IP", "SysName", "Status", "Error", "QueriedAt"}
+	xlsxSummaryColumns = [...]string{"Metric", "Value"}
 	// File types that are valid for writing
-	validFiletypes = [...]string{"csv", "json", "stdout", "xlsx"}
+	validFiletypes = [...]string{"csv", "json", "stdout", "xlsx", "vlans-csv", "vlans-json", "diff-txt", "diff-json", "diff-xlsx", "audit-txt", "audit-json", "audit-xlsx", "names-txt", "names-json", "names-xlsx"}
 )
 
 /*
@@ -46,10 +70,48 @@ var (
 ##        #######  ##    ##  ######   ######
 */
 
+// Returns the actual write* function that handles a file type
+func selectWriter(filetype string) func(string, devicesWrapper) (uint, error) {
+	switch filetype {
+	case "csv":
+		return writeResultsCSV
+	case "json":
+		return writeResultsJSON
+	case "stdout":
+		return writeResultsStdout
+	case "xlsx":
+		return writeResultsXLSX
+	case "vlans-csv":
+		return writeVlansCSV
+	case "vlans-json":
+		return writeVlansJSON
+	case "diff-txt":
+		return writeDiffText
+	case "diff-json":
+		return writeDiffJSON
+	case "diff-xlsx":
+		return writeDiffXLSX
+	case "audit-txt":
+		return writeAuditText
+	case "audit-json":
+		return writeAuditJSON
+	case "audit-xlsx":
+		return writeAuditXLSX
+	case "names-txt":
+		return writeVlanNamesText
+	case "names-json":
+		return writeVlanNamesJSON
+	case "names-xlsx":
+		return writeVlanNamesXLSX
+	}
+	return nil
+}
+
 // Decides which actual writeResults* function shall be used based on filename pre- or suffix
 func writeResults(filename string, resultsNew devicesWrapper) (errCode uint, err error) {
 	var compress bool
 	var writer func(string, devicesWrapper) (uint, error)
+	var selectedFiletype string
 
 	// Determine whether output should be compressed
 	compress = config.CompressOutput
@@ -63,16 +125,10 @@ func writeResults(filename string, resultsNew devicesWrapper) (errCode uint, err
 		prefix := fmt.Sprintf("%s:", filetype)
 		if strings.HasPrefix(filename, prefix) {
 			filename = strings.TrimPrefix(filename, prefix)
-			switch filetype {
-			case "csv":
-				writer = writeResultsCSV
-			case "json":
-				writer = writeResultsJSON
-			case "stdout":
-				writer = writeResultsStdout
+			writer = selectWriter(filetype)
+			selectedFiletype = filetype
+			if filetype == "stdout" {
 				compress = false
-			case "xlsx":
-				writer = writeResultsXLSX
 			}
 		}
 	}
@@ -81,16 +137,10 @@ func writeResults(filename string, resultsNew devicesWrapper) (errCode uint, err
 		for _, filetype := range validFiletypes {
 			suffix := fmt.Sprintf(".%s", filetype)
 			if strings.HasSuffix(filename, suffix) {
-				switch filetype {
-				case "csv":
-					writer = writeResultsCSV
-				case "json":
-					writer = writeResultsJSON
-				case "stdout":
-					writer = writeResultsStdout
+				writer = selectWriter(filetype)
+				selectedFiletype = filetype
+				if filetype == "stdout" {
 					compress = false
-				case "xlsx":
-					writer = writeResultsXLSX
 				}
 			}
 		}
@@ -99,6 +149,11 @@ func writeResults(filename string, resultsNew devicesWrapper) (errCode uint, err
 	if writer == nil {
 		return 0, fmt.Errorf("Could not determine file type for <%s>", filename)
 	}
+	// json, diff and audit outfiles always use all ports, so that snapshots stay comparable and links can be followed;
+	// xlsx collapses LAGs itself after auditing the links
+	if config.LagMode == lagModeCollapse && selectedFiletype != "json" && selectedFiletype != "xlsx" && !strings.HasPrefix(selectedFiletype, "diff-") && !strings.HasPrefix(selectedFiletype, "audit-") {
+		resultsNew = collapseLags(resultsNew)
+	}
 
 	// Actually write the file
 	errCode, err = writer(filename, resultsNew)
@@ -114,21 +169,16 @@ func writeResults(filename string, resultsNew devicesWrapper) (errCode uint, err
 	return
 }
 
-// Writes the results to outfile in CSV format
-func writeResultsCSV(filename string, results devicesWrapper) (uint, error) {
+// Writes data to outfile line by line
+func writeLinesToFile(filename string, data string) (uint, error) {
 	var rowsWritten uint = 0
 
-	csvData, csvError := results.ToCSV()
-	if csvError != nil {
-		return rowsWritten, fmt.Errorf("Could not convert data to CSV: %s", csvError)
-	}
-
 	fileHandle, fileErr := os.Create(filename)
 	if fileErr != nil {
 		return rowsWritten, fmt.Errorf("Could not create outfile: %s", fileErr)
 	}
 	fileWriter := bufio.NewWriter(fileHandle)
-	for _, line := range strings.Split(csvData, "\n") {
+	for _, line := range strings.Split(data, "\n") {
 		_, writeErr := fileWriter.WriteString(fmt.Sprintf("%s\n", line))
 		if writeErr != nil {
 			return rowsWritten, fmt.Errorf("Could not write to outfile: %s", writeErr)
@@ -151,31 +201,78 @@ func writeResultsCSV(filename string, results devicesWrapper) (uint, error) {
 	return rowsWritten, nil
 }
 
-// Writes the results to outfile in JSON format
-func writeResultsJSON(filename string, results devicesWrapper) (uint, error) {
+// Returns the rune that separates CSV fields based on the CSV delimiter option
+func csvDelimiter() (rune, error) {
+	switch config.CSVDelimiter {
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	delimiter := []rune(config.CSVDelimiter)
+	if len(delimiter) != 1 || delimiter[0] == '"' || delimiter[0] == '\r' || delimiter[0] == '\n' || delimiter[0] == utf8.RuneError {
+		return 0, fmt.Errorf("must be a single character other than quotes and line breaks, got <%s>", config.CSVDelimiter)
+	}
+	return delimiter[0], nil
+}
+
+// Writes a header and rows of values as RFC 4180 compliant CSV data
+func writeCSV(w io.Writer, header []string, rows []tableRow) (uint, error) {
 	var rowsWritten uint = 0
 
-	jsonData, jsonErr := results.ToJSON()
-	if jsonErr != nil {
-		return rowsWritten, fmt.Errorf("Could not encode JSON: %s", jsonErr)
+	delimiter, delimiterErr := csvDelimiter()
+	if delimiterErr != nil {
+		return rowsWritten, delimiterErr
 	}
 
-	fileHandle, fileErr := os.Create(filename)
-	if fileErr != nil {
-		return rowsWritten, fmt.Errorf("Could not create outfile: %s", fileErr)
+	if config.CSVBOM {
+		_, bomErr := io.WriteString(w, "\uFEFF")
+		if bomErr != nil {
+			return rowsWritten, fmt.Errorf("Could not write BOM: %s", bomErr)
+		}
 	}
-	fileWriter := bufio.NewWriter(fileHandle)
-	for _, line := range strings.Split(jsonData, "\n") {
-		_, writeErr := fileWriter.WriteString(fmt.Sprintf("%s\n", line))
-		if writeErr != nil {
-			return rowsWritten, fmt.Errorf("Could not write to outfile: %s", writeErr)
+
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Comma = delimiter
+	writeErr := csvWriter.Write(header)
+	if writeErr != nil {
+		return rowsWritten, fmt.Errorf("Could not write CSV data: %s", writeErr)
+	}
+	rowsWritten++
+	for _, row := range rows {
+		var fields []string
+		for _, value := range row {
+			fields = append(fields, fmt.Sprint(value))
 		}
-		flushErr := fileWriter.Flush()
-		if flushErr != nil {
-			stdErr.Printf("Could not flush file buffer: %s\n", flushErr)
+		writeErr = csvWriter.Write(fields)
+		if writeErr != nil {
+			return rowsWritten, fmt.Errorf("Could not write CSV data: %s", writeErr)
 		}
 		rowsWritten++
 	}
+	csvWriter.Flush()
+	if flushErr := csvWriter.Error(); flushErr != nil {
+		return rowsWritten, fmt.Errorf("Could not write CSV data: %s", flushErr)
+	}
+
+	return rowsWritten, nil
+}
+
+// Writes a header and rows of values to outfile in CSV format
+func writeCSVFile(filename string, header []string, rows []tableRow) (uint, error) {
+	fileHandle, fileErr := os.Create(filename)
+	if fileErr != nil {
+		return 0, fmt.Errorf("Could not create outfile: %s", fileErr)
+	}
+	fileWriter := bufio.NewWriter(fileHandle)
+	rowsWritten, csvErr := writeCSV(fileWriter, header, rows)
+	if csvErr != nil {
+		fileHandle.Close()
+		return rowsWritten, csvErr
+	}
+	flushErr := fileWriter.Flush()
+	if flushErr != nil {
+		fileHandle.Close()
+		return rowsWritten, fmt.Errorf("Could not write to outfile: %s", flushErr)
+	}
 	syncErr := fileHandle.Sync()
 	if syncErr != nil {
 		stdErr.Printf("Could not sync file handle: %s\n", syncErr)
@@ -188,134 +285,251 @@ func writeResultsJSON(filename string, results devicesWrapper) (uint, error) {
 	return rowsWritten, nil
 }
 
+// Writes the results to outfile in CSV format
+func writeResultsCSV(filename string, results devicesWrapper) (uint, error) {
+	columns := selectColumns(csvColumns[:])
+	return writeCSVFile(filename, columns, results.ToTable(columns))
+}
+
+// Writes the results to outfile in JSON format
+func writeResultsJSON(filename string, results devicesWrapper) (uint, error) {
+	jsonData, jsonErr := results.ToJSON()
+	if jsonErr != nil {
+		return 0, fmt.Errorf("Could not encode JSON: %s", jsonErr)
+	}
+
+	return writeLinesToFile(filename, jsonData)
+}
+
+// Writes the VLAN-centric view of the results to outfile in CSV format
+func writeVlansCSV(filename string, results devicesWrapper) (uint, error) {
+	vlans := pivotVlans(results)
+	return writeCSVFile(filename, vlanColumns[:], vlans.ToRows())
+}
+
+// Writes the VLAN-centric view of the results to outfile in JSON format
+func writeVlansJSON(filename string, results devicesWrapper) (uint, error) {
+	vlans := pivotVlans(results)
+	jsonData, jsonErr := vlans.ToJSON()
+	if jsonErr != nil {
+		return 0, fmt.Errorf("Could not encode JSON: %s", jsonErr)
+	}
+
+	return writeLinesToFile(filename, jsonData)
+}
+
 // Writes the results to stdout in CSV format
 func writeResultsStdout(filename string, results devicesWrapper) (uint, error) {
+	columns := selectColumns(csvColumns[:])
+	return writeCSV(os.Stdout, columns, results.ToTable(columns))
+}
+
+// Creates the cell styles used for coloring rows in XLSX files; the first index denotes the device, the second the row
+func newXLSXRowStyles(xlsx *excelize.File) ([][]int, error) {
+	var cellStyles [][]int
+
+	colors := [][]string{
+		{"#F2F2F2", "#E6E6E6"}, // Grey
+		{"#FFFFE6", "#FFFFCC"}, // Yellow
+		{"#E6FFE6", "#CCFFCC"}, // Green
+		{"#E6FFFF", "#CCFFFF"}, // Turqoise
+		{"#E6E6FF", "#CCCCFF"}, // Blue
+		{"#FFE6FF", "#FFCCFF"}, // Purple
+		{"#FFE6E6", "#FFCCCC"}, // Red
+	}
+
+	for baseColor := range colors {
+		cellStyles = append(cellStyles, []int{})
+		for rowColor := range colors[baseColor] {
+			styleString := fmt.Sprintf(`{"fill":{"type":"pattern","color":["%s"],"pattern":1}}`, colors[baseColor][rowColor])
+			styleFormat, styleFormatErr := xlsx.NewStyle(styleString)
+			if styleFormatErr != nil {
+				return cellStyles, styleFormatErr
+			}
+			cellStyles[baseColor] = append(cellStyles[baseColor], styleFormat)
+		}
+	}
+
+	return cellStyles, nil
+}
+
+// Fills an XLSX sheet with a header row and data rows and makes it easy to navigate
+// If groups is not nil, rows are colored based on the group (e.g. device) they belong to
+func writeXLSXSheet(xlsx *excelize.File, sheet string, header []string, rows []tableRow, groups []int, cellStyles [][]int) (uint, error) {
 	var rowsWritten uint = 0
+	var groupRow int = 0
+
+	if xlsx.GetSheetIndex(sheet) == -1 {
+		xlsx.NewSheet(sheet)
+	}
+	colWidths := make([]int, len(header))
 
-	csvData, csvError := results.ToCSV()
-	if csvError != nil {
-		return rowsWritten, fmt.Errorf("Could not convert data to CSV: %s", csvError)
+	rowErr := xlsx.SetSheetRow(sheet, "A1", &header)
+	if rowErr != nil {
+		return rowsWritten, rowErr
+	}
+	for colIndex, columnName := range header {
+		colWidths[colIndex] = len(columnName)
 	}
+	rowsWritten++
 
-	for _, line := range strings.Split(csvData, "\n") {
-		fmt.Printf("%s\n", line)
+	for rowIndex := range rows {
+		startCell, startErr := excelize.CoordinatesToCellName(1, rowIndex+2)
+		if startErr != nil {
+			return rowsWritten, startErr
+		}
+		rowErr = xlsx.SetSheetRow(sheet, startCell, &rows[rowIndex])
+		if rowErr != nil {
+			return rowsWritten, rowErr
+		}
+		for colIndex, value := range rows[rowIndex] {
+			if colIndex < len(colWidths) && len(fmt.Sprint(value)) > colWidths[colIndex] {
+				colWidths[colIndex] = len(fmt.Sprint(value))
+			}
+		}
+		if groups != nil && len(cellStyles) > 0 {
+			if rowIndex > 0 && groups[rowIndex] != groups[rowIndex-1] {
+				groupRow = 0
+			}
+			endCell, endErr := excelize.CoordinatesToCellName(len(header), rowIndex+2)
+			if endErr != nil {
+				return rowsWritten, endErr
+			}
+			devStyleID := groups[rowIndex] % len(cellStyles)
+			rowStyleID := groupRow % len(cellStyles[devStyleID])
+			styleErr := xlsx.SetCellStyle(sheet, startCell, endCell, cellStyles[devStyleID][rowStyleID])
+			if styleErr != nil {
+				stdErr.Printf("Could not set style for cells %s:%s: %s", startCell, endCell, styleErr)
+			}
+			groupRow++
+		}
 		rowsWritten++
 	}
 
+	lastCell, lastErr := excelize.CoordinatesToCellName(len(header), len(rows)+1)
+	if lastErr != nil {
+		return rowsWritten, lastErr
+	}
+	filterErr := xlsx.AutoFilter(sheet, "A1", lastCell, "")
+	if filterErr != nil {
+		stdErr.Printf("Could not set autofilter for sheet %s: %s", sheet, filterErr)
+	}
+	panesErr := xlsx.SetPanes(sheet, `{"freeze":true,"split":false,"x_split":0,"y_split":1,"top_left_cell":"A2","active_pane":"bottomLeft"}`)
+	if panesErr != nil {
+		stdErr.Printf("Could not freeze header row for sheet %s: %s", sheet, panesErr)
+	}
+	// Columns are processed backwards as excelize prepends each new column definition
+	for colIndex := len(colWidths) - 1; colIndex >= 0; colIndex-- {
+		colWidth := colWidths[colIndex]
+		colName, colErr := excelize.ColumnNumberToName(colIndex + 1)
+		if colErr != nil {
+			return rowsWritten, colErr
+		}
+		if colWidth > xlsxMaxColWidth {
+			colWidth = xlsxMaxColWidth
+		}
+		widthErr := xlsx.SetColWidth(sheet, colName, colName, float64(colWidth+2))
+		if widthErr != nil {
+			stdErr.Printf("Could not set width for column %s: %s", colName, widthErr)
+		}
+	}
+
 	return rowsWritten, nil
 }
 
 // Writes the results to outfile in XLSX format
 func writeResultsXLSX(filename string, results devicesWrapper) (uint, error) {
 	var rowsWritten uint = 0
-	var colIndex int = 1
-	var rowIndex int = 1
-	var colors map[int]map[int]string
-	var cellStyles map[int]map[int]int
-	var devCount int = 0
-	var rowCount int = 0
-	var devStyleID int = 0
-	var rowStyleID int = 0
+	var cellStyles [][]int
+	var styleErr error
 
 	xlsx := excelize.NewFile()
+	xlsx.SetSheetName("Sheet1", "Devices")
 
 	if !config.NoColor {
-		colors = make(map[int]map[int]string)
-		// Grey
-		colors[0] = make(map[int]string)
-		colors[0][0] = "#F2F2F2"
-		colors[0][1] = "#E6E6E6"
-		// Yellow
-		colors[1] = make(map[int]string)
-		colors[1][0] = "#FFFFE6"
-		colors[1][1] = "#FFFFCC"
-		// Green
-		colors[2] = make(map[int]string)
-		colors[2][0] = "#E6FFE6"
-		colors[2][1] = "#CCFFCC"
-		// Turqoise
-		colors[3] = make(map[int]string)
-		colors[3][0] = "#E6FFFF"
-		colors[3][1] = "#CCFFFF"
-		// Blue
-		colors[4] = make(map[int]string)
-		colors[4][0] = "#E6E6FF"
-		colors[4][1] = "#CCCCFF"
-		// Purple
-		colors[5] = make(map[int]string)
-		colors[5][0] = "#FFE6FF"
-		colors[5][1] = "#FFCCFF"
-		// Red
-		colors[6] = make(map[int]string)
-		colors[6][0] = "#FFE6E6"
-		colors[6][1] = "#FFCCCC"
-
-		cellStyles = make(map[int]map[int]int)
-		for baseColor := range colors {
-			cellStyles[baseColor] = make(map[int]int)
-			for rowColor := range colors[baseColor] {
-				styleString := fmt.Sprintf(`{"fill":{"type":"pattern","color":["%s"],"pattern":1}}`, colors[baseColor][rowColor])
-				styleFormat, styleFormatErr := xlsx.NewStyle(styleString)
-				if styleFormatErr != nil {
-					return rowsWritten, styleFormatErr
-				}
-				cellStyles[baseColor][rowColor] = styleFormat
-			}
+		cellStyles, styleErr = newXLSXRowStyles(xlsx)
+		if styleErr != nil {
+			return rowsWritten, styleErr
 		}
 	}
 
-	for _, columnName := range csvColumns {
-		position, positionErr := excelize.CoordinatesToCellName(colIndex, rowIndex)
-		if positionErr != nil {
-			return rowsWritten, positionErr
-		}
-		valueErr := xlsx.SetCellValue("Sheet1", position, columnName)
-		if valueErr != nil {
-			stdErr.Printf("Could not set value for %s: %s", position, valueErr)
-		}
-		colIndex++
+	audit := auditLinks(results)
+	if config.LagMode == lagModeCollapse {
+		results = collapseLags(results)
 	}
-	rowsWritten++
 
-	for _, dev := range results.Devices {
-		csvRows, csvRowsErr := dev.ToCSVRows()
-		if csvRowsErr != nil {
-			stdErr.Printf("Could not convert device to CSV rows: %s", csvRowsErr)
-			continue
+	deviceRows, deviceGroups := results.ToDeviceRows()
+	portColumns := selectColumns(xlsxPortColumns[:])
+	portRows, portGroups := results.ToPortRows(portColumns)
+	vlanRows, vlanGroups := results.ToVlanRows()
+	failureRows, failureGroups := results.ToFailureRows()
+	vlans := pivotVlans(results)
+
+	sheets := []struct {
+		Name   string
+		Header []string
+		Rows   []tableRow
+		Groups []int
+	}{
+		{"Devices", xlsxDeviceColumns[:], deviceRows, deviceGroups},
+		{"Ports", portColumns, portRows, portGroups},
+		{"VLANs", xlsxVlanColumns[:], vlanRows, vlanGroups},
+		{"VLAN Usage", vlanColumns[:], vlans.ToRows(), nil},
+		{"Failures", xlsxFailureColumns[:], failureRows, failureGroups},
+		{"Audit", auditColumns[:], audit.ToRows(), nil},
+		{"Summary", xlsxSummaryColumns[:], results.ToSummaryRows(), nil},
+	}
+	for _, sheet := range sheets {
+		sheetRows, sheetErr := writeXLSXSheet(xlsx, sheet.Name, sheet.Header, sheet.Rows, sheet.Groups, cellStyles)
+		rowsWritten += sheetRows
+		if sheetErr != nil {
+			return rowsWritten, fmt.Errorf("Could not write sheet %s: %s", sheet.Name, sheetErr)
 		}
-		for _, row := range csvRows {
-			colIndex = 1
-			rowIndex++
-			if !config.NoColor {
-				devStyleID = devCount % len(cellStyles)
-				rowStyleID = rowCount % len(cellStyles[devStyleID])
-			}
-			for _, element := range strings.Split(row, `","`) {
-				element = strings.Trim(element, `"`)
-				position, positionErr := excelize.CoordinatesToCellName(colIndex, rowIndex)
-				if positionErr != nil {
-					return rowsWritten, positionErr
-				}
-				valueErr := xlsx.SetCellValue("Sheet1", position, element)
-				if valueErr != nil {
-					stdErr.Printf("Could not set value for %s: %s", position, valueErr)
-				}
-				if !config.NoColor {
-					styleErr := xlsx.SetCellStyle("Sheet1", position, position, cellStyles[devStyleID][rowStyleID])
-					if styleErr != nil {
-						stdErr.Printf("Could not set style for cell %s: %s", position, styleErr)
-					}
-				}
-				colIndex++
-			}
-			rowsWritten++
-			rowCount++
+	}
+	xlsx.SetActiveSheet(xlsx.GetSheetIndex("Devices"))
+
+	if saveErr := xlsx.SaveAs(filename); saveErr != nil {
+		return rowsWritten, saveErr
+	}
+
+	return rowsWritten, nil
+}
+
+// Writes a table to outfile as aligned plain text
+func writeTableText(filename string, header []string, rows []tableRow) (uint, error) {
+	var textData strings.Builder
+
+	tableWriter := tabwriter.NewWriter(&textData, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tableWriter, strings.Join(header, "\t"))
+	for _, row := range rows {
+		var fields []string
+		for _, value := range row {
+			fields = append(fields, fmt.Sprint(value))
 		}
-		devCount++
-		rowCount = 0
+		fmt.Fprintln(tableWriter, strings.Join(fields, "\t"))
+	}
+	flushErr := tableWriter.Flush()
+	if flushErr != nil {
+		return 0, fmt.Errorf("Could not format table: %s", flushErr)
 	}
 
-	xlsx.SetSheetName("Sheet1", time.Now().Format(time.RFC3339))
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(textData.String(), "\n"), "\n") {
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+
+	return writeLinesToFile(filename, strings.Join(lines, "\n"))
+}
+
+// Writes a table to outfile as a single XLSX sheet
+func writeTableXLSX(filename string, sheetName string, header []string, rows []tableRow) (uint, error) {
+	xlsx := excelize.NewFile()
+	xlsx.SetSheetName("Sheet1", sheetName)
+
+	rowsWritten, sheetErr := writeXLSXSheet(xlsx, sheetName, header, rows, nil, nil)
+	if sheetErr != nil {
+		return rowsWritten, sheetErr
+	}
 
 	if saveErr := xlsx.SaveAs(filename); saveErr != nil {
 		return rowsWritten, saveErr
@@ -324,6 +538,41 @@ func writeResultsXLSX(filename string, results devicesWrapper) (uint, error) {
 	return rowsWritten, nil
 }
 
+// Writes the differences to a snapshot to outfile in plain text format
+func writeDiffText(filename string, results devicesWrapper) (uint, error) {
+	diff, diffErr := diffAgainstBaseline(results)
+	if diffErr != nil {
+		return 0, diffErr
+	}
+
+	return writeTableText(filename, diffColumns[:], diff.ToRows())
+}
+
+// Writes the differences to a snapshot to outfile in JSON format
+func writeDiffJSON(filename string, results devicesWrapper) (uint, error) {
+	diff, diffErr := diffAgainstBaseline(results)
+	if diffErr != nil {
+		return 0, diffErr
+	}
+
+	jsonData, jsonErr := diff.ToJSON()
+	if jsonErr != nil {
+		return 0, fmt.Errorf("Could not encode JSON: %s", jsonErr)
+	}
+
+	return writeLinesToFile(filename, jsonData)
+}
+
+// Writes the differences to a snapshot to outfile in XLSX format
+func writeDiffXLSX(filename string, results devicesWrapper) (uint, error) {
+	diff, diffErr := diffAgainstBaseline(results)
+	if diffErr != nil {
+		return 0, diffErr
+	}
+
+	return writeTableXLSX(filename, "Diff", diffColumns[:], diff.ToRows())
+}
+
 // Compresses an file using gzip
 func compressFile(filename string) (err error) {
 	var data []byte
@@ -357,3 +606,49 @@ func compressFile(filename string) (err error) {
 
 	return
 }
+
+// Writes the VLAN consistency of all links between devices to outfile in plain text format
+func writeAuditText(filename string, results devicesWrapper) (uint, error) {
+	audit := auditLinks(results)
+	return writeTableText(filename, auditColumns[:], audit.ToRows())
+}
+
+// Writes the VLAN consistency of all links between devices to outfile in JSON format
+func writeAuditJSON(filename string, results devicesWrapper) (uint, error) {
+	audit := auditLinks(results)
+	jsonData, jsonErr := audit.ToJSON()
+	if jsonErr != nil {
+		return 0, fmt.Errorf("Could not encode JSON: %s", jsonErr)
+	}
+
+	return writeLinesToFile(filename, jsonData)
+}
+
+// Writes the VLAN consistency of all links between devices to outfile in XLSX format
+func writeAuditXLSX(filename string, results devicesWrapper) (uint, error) {
+	audit := auditLinks(results)
+	return writeTableXLSX(filename, "Link Audit", auditColumns[:], audit.ToRows())
+}
+
+// Writes VLAN names that differ between devices or from the catalog to outfile in plain text format
+func writeVlanNamesText(filename string, results devicesWrapper) (uint, error) {
+	audit := auditVlanNames(results, vlanCatalog)
+	return writeTableText(filename, vlanNameColumns[:], audit.ToRows())
+}
+
+// Writes VLAN names that differ between devices or from the catalog to outfile in JSON format
+func writeVlanNamesJSON(filename string, results devicesWrapper) (uint, error) {
+	audit := auditVlanNames(results, vlanCatalog)
+	jsonData, jsonErr := audit.ToJSON()
+	if jsonErr != nil {
+		return 0, fmt.Errorf("Could not encode JSON: %s", jsonErr)
+	}
+
+	return writeLinesToFile(filename, jsonData)
+}
+
+// Writes VLAN names that differ between devices or from the catalog to outfile in XLSX format
+func writeVlanNamesXLSX(filename string, results devicesWrapper) (uint, error) {
+	audit := auditVlanNames(results, vlanCatalog)
+	return writeTableXLSX(filename, "VLAN Names", vlanNameColumns[:], audit.ToRows())
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteCSV(t *testing.T) {
+	header := []string{"IP", "Name"}
+	tests := []struct {
+		name      string
+		delimiter string
+		rows      []tableRow
+		want      string
+	}{
+		{"plain", ",", []tableRow{{"10.0.0.1", "core-sw1"}}, "IP,Name\n10.0.0.1,core-sw1\n"},
+		{"numbers", ",", []tableRow{{10, 2.5}}, "IP,Name\n10,2.5\n"},
+		{"delimiter in field", ",", []tableRow{{"10.0.0.1", "Building A, Room 1"}}, "IP,Name\n10.0.0.1,\"Building A, Room 1\"\n"},
+		{"quotes in field", ",", []tableRow{{"10.0.0.1", `Uplink "core"`}}, "IP,Name\n10.0.0.1,\"Uplink \"\"core\"\"\"\n"},
+		{"newline in field", ",", []tableRow{{"10.0.0.1", "line 1\nline 2"}}, "IP,Name\n10.0.0.1,\"line 1\nline 2\"\n"},
+		{"semicolon", ";", []tableRow{{"10.0.0.1", "Building A, Room 1"}, {"10.0.0.2", "a;b"}}, "IP;Name\n10.0.0.1;Building A, Room 1\n10.0.0.2;\"a;b\"\n"},
+		{"tab", "tab", []tableRow{{"10.0.0.1", "Building A, Room 1"}, {"10.0.0.2", "a\tb"}}, "IP\tName\n10.0.0.1\tBuilding A, Room 1\n10.0.0.2\t\"a\tb\"\n"},
+		{"escaped tab", `\t`, []tableRow{{"10.0.0.1", "core-sw1"}}, "IP\tName\n10.0.0.1\tcore-sw1\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config = appConfig{CSVDelimiter: test.delimiter}
+			var output bytes.Buffer
+			rowsWritten, csvErr := writeCSV(&output, header, test.rows)
+			if csvErr != nil {
+				t.Fatalf("writeCSV() returned error: %s", csvErr)
+			}
+			if output.String() != test.want {
+				t.Errorf("writeCSV() wrote %q, want %q", output.String(), test.want)
+			}
+			if rowsWritten != uint(len(test.rows)+1) {
+				t.Errorf("writeCSV() reported %d row(s), want %d", rowsWritten, len(test.rows)+1)
+			}
+		})
+	}
+}
+
+func TestWriteCSVInvalidDelimiter(t *testing.T) {
+	for _, delimiter := range []string{"", ";;", `"`, "\n"} {
+		config = appConfig{CSVDelimiter: delimiter}
+		if _, csvErr := writeCSV(&bytes.Buffer{}, []string{"IP"}, nil); csvErr == nil {
+			t.Errorf("writeCSV() with delimiter %q returned no error", delimiter)
+		}
+	}
+}
+
+func TestWriteCSVFileBOM(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "results.csv")
+	config = appConfig{CSVDelimiter: ";", CSVBOM: true}
+
+	if _, csvErr := writeCSVFile(filename, []string{"IP", "Name"}, []tableRow{{"10.0.0.1", "core-sw1"}, {"10.0.0.2", "access-sw1"}}); csvErr != nil {
+		t.Fatalf("writeCSVFile() returned error: %s", csvErr)
+	}
+	content, _ := ioutil.ReadFile(filename)
+	if !bytes.HasPrefix(content, []byte("\uFEFFIP;Name\n")) {
+		t.Errorf("outfile is %q, want BOM followed by the header", content)
+	}
+	if count := strings.Count(string(content), "\uFEFF"); count != 1 {
+		t.Errorf("outfile contains %d BOM(s), want 1", count)
+	}
+}
@@ -11,9 +11,67 @@ package main
 */
 
 import (
+	"context"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	xmcnbiclient "gitlab.com/rbrt-weiler/go-module-xmcnbiclient"
 )
 
+/*
+ ######   #######  ##    ##  ######  ########    ###    ##    ## ########  ######
+##    ## ##     ## ###   ## ##    ##    ##      ## ##   ###   ##    ##    ##    ##
+##       ##     ## ####  ## ##          ##     ##   ##  ####  ##    ##    ##
+##       ##     ## ## ## ##  ######     ##    ##     ## ## ## ##    ##     ######
+##       ##     ## ##  ####       ##    ##    ######### ##  ####    ##          ##
+##    ## ##     ## ##   ### ##    ##    ##    ##     ## ##   ###    ##    ##    ##
+ ######   #######  ##    ##  ######     ##    ##     ## ##    ##    ##     ######
+*/
+
+const (
+	// Upper limit for the delay between two retries
+	maxRetryDelay time.Duration = time.Minute
+
+	// Lowercase texts of the errors returned by xmcnbiclient v0.6.0 that retryableError depends on.
+	// TestXMCClientErrorTexts fails if an upgraded library words them differently.
+	xmcErrStatusCode   string = "got status code"
+	xmcErrConnect      string = "could not connect to xmc"
+	xmcErrReadResponse string = "could not read server response"
+	xmcErrTokenRefresh string = "could not retrieve fresh oauth token"
+	// Lowercase texts of net errors that show that a request never reached XMC
+	netErrRefused    string = "connection refused"
+	netErrNoSuchHost string = "no such host"
+)
+
+/*
+##     ##    ###    ########   ######
+##     ##   ## ##   ##     ## ##    ##
+##     ##  ##   ##  ##     ## ##
+##     ## ##     ## ########   ######
+ ##   ##  ######### ##   ##         ##
+  ## ##   ##     ## ##    ##  ##    ##
+   ###    ##     ## ##     ##  ######
+*/
+
+var (
+	// Guards the OAuth token of the XMC client against concurrent refreshes
+	clientMutex sync.RWMutex
+	// Number of queries that were retried; accessed atomically
+	retryCount uint64
+	// Extracts the HTTP status code from errors returned by the XMC client
+	statusCodeRegex = regexp.MustCompile(`(?i)` + xmcErrStatusCode + ` (\d+)`)
+	// Random number generator used for retry jitter; guarded by jitterMutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	// Guards jitterRand, which is not safe for concurrent use
+	jitterMutex sync.Mutex
+)
+
 /*
 ######## ##     ## ##    ##  ######   ######
 ##       ##     ## ###   ## ##    ## ##    ##
@@ -24,10 +82,24 @@ import (
 ##        #######  ##    ##  ######   ######
 */
 
+// Brings a path into the form expected by the XMC client: leading slash, no trailing slash
+func normalizeBasePath(basePath string) string {
+	basePath = strings.Trim(strings.TrimSpace(basePath), "/")
+	if basePath == "" {
+		return ""
+	}
+	return fmt.Sprintf("/%s", basePath)
+}
+
 // Initializes the actual XMC client
 func initializeClient(client *xmcnbiclient.NBIClient) {
 	*client = xmcnbiclient.New(config.XMCHost)
 	client.SetUserAgent(toolID)
+	portErr := client.SetPort(config.XMCPort)
+	if portErr != nil {
+		stdErr.Fatalf("Could not set HTTP port: %s\n", portErr)
+	}
+	client.SetBasePath(normalizeBasePath(config.XMCPath))
 	client.UseHTTPS()
 	if config.NoHTTPS {
 		client.UseHTTP()
@@ -47,10 +119,164 @@ func initializeClient(client *xmcnbiclient.NBIClient) {
 }
 
 // Refreshes the OAuth token if it is to expire soon
-func proactiveTokenRefresh(client *xmcnbiclient.NBIClient) {
-	if client.Authentication.Type == xmcnbiclient.AuthTypeOAuth {
-		if client.AccessToken.ExpiresSoon(config.HTTPTimeout + 1) {
-			go client.RetrieveOAuthToken()
+func proactiveTokenRefresh(client *xmcnbiclient.NBIClient) error {
+	if client.Authentication.Type != xmcnbiclient.AuthTypeOAuth {
+		return nil
+	}
+	clientMutex.Lock()
+	defer clientMutex.Unlock()
+	if client.AccessToken.ExpiresSoon(config.HTTPTimeout + 1) {
+		return client.RetrieveOAuthToken()
+	}
+	return nil
+}
+
+// Sends a query to XMC once; safe for concurrent use.
+// The XMC client cannot cancel requests, so a cancelled ctx only stops waiting for the response.
+func queryAPIOnce(ctx context.Context, client *xmcnbiclient.NBIClient, query string) ([]byte, error) {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	tokenErr := proactiveTokenRefresh(client)
+	if tokenErr != nil {
+		return nil, fmt.Errorf("Could not retrieve fresh OAuth token: %s", tokenErr)
+	}
+
+	type queryResult struct {
+		Body []byte
+		Err  error
+	}
+	resultChannel := make(chan queryResult, 1)
+	go func() {
+		clientMutex.RLock()
+		defer clientMutex.RUnlock()
+		body, queryErr := client.QueryAPI(query)
+		resultChannel <- queryResult{body, queryErr}
+	}()
+
+	select {
+	case result := <-resultChannel:
+		if queryRecorder != nil {
+			if recordErr := queryRecorder.Record(query, result.Body, result.Err); recordErr != nil {
+				stdErr.Println(recordErr)
+			}
+		}
+		return result.Body, result.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Returns the HTTP status code contained in an error returned by the XMC client, or 0
+func errorStatusCode(queryErr error) int {
+	match := statusCodeRegex.FindStringSubmatch(queryErr.Error())
+	if match == nil {
+		return 0
+	}
+	statusCode, _ := strconv.Atoi(match[1])
+	return statusCode
+}
+
+// Decides whether a failed query may succeed if it is sent again.
+// A 401 is only retried with OAuth, where it usually means an expired token.
+// Queries that are not idempotent are only retried if XMC cannot have received them.
+func retryableError(queryErr error, oauth bool, idempotent bool) bool {
+	errText := strings.ToLower(queryErr.Error())
+	// The query itself is not sent if no token could be retrieved
+	if strings.Contains(errText, xmcErrTokenRefresh) {
+		return true
+	}
+	statusCode := errorStatusCode(queryErr)
+	switch {
+	case statusCode == 401:
+		return oauth
+	case statusCode == 429:
+		return true
+	case statusCode >= 500:
+		return idempotent
+	case statusCode != 0:
+		return false
+	}
+	if !idempotent {
+		// Only retry if the query never reached XMC
+		return strings.Contains(errText, xmcErrConnect) && (strings.Contains(errText, netErrRefused) || strings.Contains(errText, netErrNoSuchHost))
+	}
+	for _, transient := range []string{xmcErrConnect, xmcErrReadResponse} {
+		if strings.Contains(errText, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the delay before the given retry (starting at 0): exponential backoff with jitter
+func retryDelay(retry uint) time.Duration {
+	delay := time.Millisecond * time.Duration(config.RetryDelay)
+	for i := uint(0); i < retry && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	// Spread the retries of concurrent workers between half and the full delay
+	if delay > 1 {
+		jitterMutex.Lock()
+		delay = delay/2 + time.Duration(jitterRand.Int63n(int64(delay/2)+1))
+		jitterMutex.Unlock()
+	}
+	return delay
+}
+
+// Waits for the given duration; returns early with an error if ctx is cancelled
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sends a query to XMC, retrying transient errors; safe for concurrent use.
+// When replaying, the recorded response is returned instead.
+func queryAPI(ctx context.Context, client *xmcnbiclient.NBIClient, query string) ([]byte, error) {
+	return sendQuery(ctx, client, query, true)
+}
+
+// Sends a mutation to XMC; like queryAPI, but without retrying errors after which XMC may already have applied it.
+func mutateAPI(ctx context.Context, client *xmcnbiclient.NBIClient, mutation string) ([]byte, error) {
+	return sendQuery(ctx, client, mutation, false)
+}
+
+// Sends a query to XMC, retrying the errors that retryableError deems safe to retry.
+func sendQuery(ctx context.Context, client *xmcnbiclient.NBIClient, query string, idempotent bool) ([]byte, error) {
+	if queryReplayer != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return queryReplayer.Replay(query)
+	}
+	for retry := uint(0); ; retry++ {
+		body, queryErr := queryAPIOnce(ctx, client, query)
+		oauth := client.Authentication.Type == xmcnbiclient.AuthTypeOAuth
+		if queryErr == nil || retry >= config.Retries || !retryableError(queryErr, oauth, idempotent) {
+			return body, queryErr
+		}
+
+		// An unauthorized request most likely means that the OAuth token has expired
+		if errorStatusCode(queryErr) == 401 {
+			clientMutex.Lock()
+			client.AccessToken = xmcnbiclient.OAuthToken{}
+			clientMutex.Unlock()
+		}
+
+		atomic.AddUint64(&retryCount, 1)
+		delay := retryDelay(retry)
+		stdErr.Printf("Query failed (%s), retry %d of %d in %s...\n", queryErr, retry+1, config.Retries, delay.Round(time.Millisecond))
+		if sleepErr := sleepContext(ctx, delay); sleepErr != nil {
+			return nil, sleepErr
 		}
 	}
 }
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	xmcnbiclient "gitlab.com/rbrt-weiler/go-module-xmcnbiclient"
+)
+
+// Returns an unsigned OAuth token that is valid for one hour
+func testOAuthToken() string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"test","exp":%d}`, time.Now().Add(time.Hour).Unix())))
+	signature := base64.RawURLEncoding.EncodeToString([]byte("signature"))
+	return fmt.Sprintf("%s.%s.%s", header, payload, signature)
+}
+
+// Starts a stand-in for XMC that serves the token and API endpoints below pathPrefix
+func newTestXMC(t *testing.T, pathPrefix string) (*httptest.Server, *int, *int) {
+	var tokenRequests int
+	var apiRequests int
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(pathPrefix+"/oauth/token/access-token", func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token_type":"Bearer","access_token":"%s"}`, testOAuthToken())
+	})
+	mux.HandleFunc(pathPrefix+"/nbi/graphql", func(w http.ResponseWriter, r *http.Request) {
+		apiRequests++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data":{"network":{"devices":[{"up":true,"ip":"192.0.2.1"}]}}}`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server, &tokenRequests, &apiRequests
+}
+
+// Points the app config to a test server
+func configureTestXMC(t *testing.T, server *httptest.Server, path string) {
+	serverURL, urlErr := url.Parse(server.URL)
+	if urlErr != nil {
+		t.Fatalf("Could not parse server URL: %s", urlErr)
+	}
+	host, port, splitErr := net.SplitHostPort(serverURL.Host)
+	if splitErr != nil {
+		t.Fatalf("Could not split server address: %s", splitErr)
+	}
+	portNumber, portErr := strconv.ParseUint(port, 10, 32)
+	if portErr != nil {
+		t.Fatalf("Could not parse server port: %s", portErr)
+	}
+
+	config = appConfig{
+		XMCHost:     host,
+		XMCPort:     uint(portNumber),
+		XMCPath:     path,
+		HTTPTimeout: 5,
+		NoHTTPS:     true,
+		XMCUserID:   "client",
+		XMCSecret:   "secret",
+	}
+}
+
+func TestNormalizeBasePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", ""},
+		{"/", ""},
+		{"xmc", "/xmc"},
+		{"/xmc", "/xmc"},
+		{"/xmc/", "/xmc"},
+		{" proxy/xmc/ ", "/proxy/xmc"},
+	}
+	for _, test := range tests {
+		if got := normalizeBasePath(test.path); got != test.want {
+			t.Errorf("normalizeBasePath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestInitializeClientPortAndPath(t *testing.T) {
+	var client xmcnbiclient.NBIClient
+
+	server, _, _ := newTestXMC(t, "/proxy/xmc")
+	configureTestXMC(t, server, "proxy/xmc/")
+	initializeClient(&client)
+
+	if client.HTTPPort != config.XMCPort {
+		t.Errorf("initializeClient() set port %d, want %d", client.HTTPPort, config.XMCPort)
+	}
+	if client.BasePath != "/proxy/xmc" {
+		t.Errorf("initializeClient() set base path %q, want %q", client.BasePath, "/proxy/xmc")
+	}
+	wantAPIURL := fmt.Sprintf("%s/proxy/xmc/nbi/graphql", server.URL)
+	if client.APIURL() != wantAPIURL {
+		t.Errorf("initializeClient() resulted in API URL %q, want %q", client.APIURL(), wantAPIURL)
+	}
+}
+
+func TestQueryAPIOAuthBelowPath(t *testing.T) {
+	var client xmcnbiclient.NBIClient
+
+	server, tokenRequests, apiRequests := newTestXMC(t, "/proxy/xmc")
+	configureTestXMC(t, server, "/proxy/xmc")
+	initializeClient(&client)
+
+	body, bodyErr := queryAPI(context.Background(), &client, gqlDeviceListQuery)
+	if bodyErr != nil {
+		t.Fatalf("queryAPI() returned error: %s", bodyErr)
+	}
+	if len(body) == 0 {
+		t.Errorf("queryAPI() returned empty body")
+	}
+	if *tokenRequests != 1 {
+		t.Errorf("token endpoint was requested %d time(s), want 1", *tokenRequests)
+	}
+	if *apiRequests != 1 {
+		t.Errorf("API endpoint was requested %d time(s), want 1", *apiRequests)
+	}
+}
+
+func TestQueryAPIBasicAuthBelowPath(t *testing.T) {
+	var client xmcnbiclient.NBIClient
+
+	server, tokenRequests, apiRequests := newTestXMC(t, "/xmc")
+	configureTestXMC(t, server, "/xmc")
+	config.BasicAuth = true
+	initializeClient(&client)
+
+	_, bodyErr := queryAPI(context.Background(), &client, gqlDeviceListQuery)
+	if bodyErr != nil {
+		t.Fatalf("queryAPI() returned error: %s", bodyErr)
+	}
+	if *tokenRequests != 0 {
+		t.Errorf("token endpoint was requested %d time(s), want 0", *tokenRequests)
+	}
+	if *apiRequests != 1 {
+		t.Errorf("API endpoint was requested %d time(s), want 1", *apiRequests)
+	}
+}
+
+func TestQueryAPIWrongPath(t *testing.T) {
+	var client xmcnbiclient.NBIClient
+
+	server, _, apiRequests := newTestXMC(t, "/xmc")
+	configureTestXMC(t, server, "")
+	config.BasicAuth = true
+	initializeClient(&client)
+
+	_, bodyErr := queryAPI(context.Background(), &client, gqlDeviceListQuery)
+	if bodyErr == nil {
+		t.Errorf("queryAPI() did not return an error for a missing path")
+	}
+	if *apiRequests != 0 {
+		t.Errorf("API endpoint was requested %d time(s), want 0", *apiRequests)
+	}
+}
+
+func TestRetryableError(t *testing.T) {
+	tests := []struct {
+		err        string
+		oauth      bool
+		idempotent bool
+		want       bool
+	}{
+		{"Could not connect to XMC: context deadline exceeded (Client.Timeout exceeded while awaiting headers)", false, true, true},
+		{"Could not connect to XMC: context deadline exceeded (Client.Timeout exceeded while awaiting headers)", false, false, false},
+		{"Could not connect to XMC: dial tcp 127.0.0.1:8443: connect: connection refused", false, false, true},
+		{"Could not read server response", false, true, true},
+		{"Could not read server response", false, false, false},
+		{"could not retrieve fresh OAuth token: Got status code 503 instead of 200", true, false, true},
+		{"Got status code 500 instead of 200", false, true, true},
+		{"Got status code 503 instead of 200", false, true, true},
+		{"Got status code 503 instead of 200", false, false, false},
+		{"Got status code 429 instead of 200", false, false, true},
+		{"Got status code 401 instead of 200", true, false, true},
+		{"Got status code 401 instead of 200", false, true, false},
+		{"Got status code 404 instead of 200", false, true, false},
+		{"Got status code 400 instead of 200", false, true, false},
+		{"Content-Type text/html is not supported", false, true, false},
+	}
+	for _, test := range tests {
+		if got := retryableError(fmt.Errorf("%s", test.err), test.oauth, test.idempotent); got != test.want {
+			t.Errorf("retryableError(%q, %t, %t) = %t, want %t", test.err, test.oauth, test.idempotent, got, test.want)
+		}
+	}
+}
+
+func TestXMCClientErrorTexts(t *testing.T) {
+	tests := []struct {
+		name      string
+		basicAuth bool
+		handler   http.HandlerFunc
+		closed    bool
+		want      []string
+	}{
+		{"status code", true, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, false, []string{xmcErrStatusCode}},
+		{"connection refused", true, nil, true, []string{xmcErrConnect, netErrRefused}},
+		{"truncated response", true, func(w http.ResponseWriter, r *http.Request) {
+			conn, _, hijackErr := w.(http.Hijacker).Hijack()
+			if hijackErr != nil {
+				return
+			}
+			fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{")
+			conn.Close()
+		}, false, []string{xmcErrReadResponse}},
+		{"OAuth token", false, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, false, []string{xmcErrTokenRefresh, xmcErrStatusCode}},
+	}
+	for _, test := range tests {
+		var client xmcnbiclient.NBIClient
+
+		server := httptest.NewServer(test.handler)
+		configureTestXMC(t, server, "")
+		config.BasicAuth = test.basicAuth
+		initializeClient(&client)
+		if test.closed {
+			server.Close()
+		}
+
+		_, queryErr := client.QueryAPI(gqlDeviceListQuery)
+		server.Close()
+		if queryErr == nil {
+			t.Errorf("%s: QueryAPI() returned no error", test.name)
+			continue
+		}
+		for _, text := range test.want {
+			if !strings.Contains(strings.ToLower(queryErr.Error()), text) {
+				t.Errorf("%s: error %q does not contain %q; adjust the error texts to the XMC client", test.name, queryErr, text)
+			}
+		}
+		if !retryableError(queryErr, !test.basicAuth, true) {
+			t.Errorf("%s: retryableError(%q) = false, want true", test.name, queryErr)
+		}
+	}
+	if statusCode := errorStatusCode(fmt.Errorf("Got status code 503 instead of 200")); statusCode != 503 {
+		t.Errorf("errorStatusCode() = %d, want 503", statusCode)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	config = appConfig{RetryDelay: 1000}
+	for retry, base := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		delay := retryDelay(uint(retry))
+		if delay < base/2 || delay > base {
+			t.Errorf("retryDelay(%d) = %s, want between %s and %s", retry, delay, base/2, base)
+		}
+	}
+	if delay := retryDelay(20); delay > maxRetryDelay {
+		t.Errorf("retryDelay(20) = %s, want at most %s", delay, maxRetryDelay)
+	}
+}
+
+func TestQueryAPIRetriesTransientErrors(t *testing.T) {
+	var client xmcnbiclient.NBIClient
+	var apiRequests int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiRequests++
+		if apiRequests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data":{"network":{"devices":[]}}}`)
+	}))
+	t.Cleanup(server.Close)
+	configureTestXMC(t, server, "")
+	config.BasicAuth = true
+	config.Retries = 2
+	config.RetryDelay = 1
+	initializeClient(&client)
+
+	_, bodyErr := queryAPI(context.Background(), &client, gqlDeviceListQuery)
+	if bodyErr != nil {
+		t.Fatalf("queryAPI() returned error: %s", bodyErr)
+	}
+	if apiRequests != 2 {
+		t.Errorf("API endpoint was requested %d time(s), want 2", apiRequests)
+	}
+}
+
+func TestQueryAPIGivesUpAfterRetries(t *testing.T) {
+	var client xmcnbiclient.NBIClient
+	var apiRequests int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiRequests++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(server.Close)
+	configureTestXMC(t, server, "")
+	config.BasicAuth = true
+	config.Retries = 2
+	config.RetryDelay = 1
+	initializeClient(&client)
+
+	_, bodyErr := queryAPI(context.Background(), &client, gqlDeviceListQuery)
+	if bodyErr == nil {
+		t.Errorf("queryAPI() did not return an error for a failing server")
+	}
+	if apiRequests != 3 {
+		t.Errorf("API endpoint was requested %d time(s), want 3", apiRequests)
+	}
+}
+
+func TestMutateAPIDoesNotRetryServerErrors(t *testing.T) {
+	var client xmcnbiclient.NBIClient
+	var apiRequests int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiRequests++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(server.Close)
+	configureTestXMC(t, server, "")
+	config.BasicAuth = true
+	config.Retries = 2
+	config.RetryDelay = 1
+	initializeClient(&client)
+
+	_, bodyErr := mutateAPI(context.Background(), &client, fmt.Sprintf(gqlMutationQuery, fmt.Sprintf(gqlMutationDevice, graphQLString("10.0.0.1"))))
+	if bodyErr == nil {
+		t.Errorf("mutateAPI() did not return an error for a failing server")
+	}
+	if apiRequests != 1 {
+		t.Errorf("API endpoint was requested %d time(s), want 1", apiRequests)
+	}
+}
+
+func TestQueryAPIBasicAuthUnauthorized(t *testing.T) {
+	var client xmcnbiclient.NBIClient
+	var apiRequests int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiRequests++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(server.Close)
+	configureTestXMC(t, server, "")
+	config.BasicAuth = true
+	config.Retries = 2
+	config.RetryDelay = 1
+	initializeClient(&client)
+
+	_, bodyErr := queryAPI(context.Background(), &client, gqlDeviceListQuery)
+	if bodyErr == nil {
+		t.Errorf("queryAPI() did not return an error for rejected credentials")
+	}
+	if apiRequests != 1 {
+		t.Errorf("API endpoint was requested %d time(s), want 1", apiRequests)
+	}
+}
+
+func TestQueryAPICancelled(t *testing.T) {
+	var client xmcnbiclient.NBIClient
+
+	server, _, apiRequests := newTestXMC(t, "")
+	configureTestXMC(t, server, "")
+	config.BasicAuth = true
+	config.Retries = 3
+	initializeClient(&client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, bodyErr := queryAPI(ctx, &client, gqlDeviceListQuery)
+	if bodyErr != context.Canceled {
+		t.Errorf("queryAPI() returned error %v, want %v", bodyErr, context.Canceled)
+	}
+	if *apiRequests != 0 {
+		t.Errorf("API endpoint was requested %d time(s), want 0", *apiRequests)
+	}
+}
@@ -1,6 +1,6 @@
 module gitlab.com/rbrt-weiler/xmc-nbi-vlanlister-go
 
-go 1.14
+go 1.16
 
 require (
 	github.com/360EntSecGroup-Skylar/excelize/v2 v2.2.0
@@ -10,4 +10,5 @@ require (
 	gitlab.com/rbrt-weiler/go-module-envordef v0.1.2
 	gitlab.com/rbrt-weiler/go-module-xmcnbiclient v0.6.0
 	golang.org/x/net v0.0.0-20200602114024-627f9648deb9 // indirect
+	gopkg.in/yaml.v2 v2.2.8
 )
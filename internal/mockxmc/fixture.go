@@ -0,0 +1,176 @@
+package mockxmc
+
+/*
+#### ##     ## ########   #######  ########  ########  ######
+ ##  ###   ### ##     ## ##     ## ##     ##    ##    ##    ##
+ ##  #### #### ##     ## ##     ## ##     ##    ##    ##
+ ##  ## ### ## ########  ##     ## ########     ##     ######
+ ##  ##     ## ##        ##     ## ##   ##      ##          ##
+ ##  ##     ## ##        ##     ## ##    ##     ##    ##    ##
+#### ##     ## ##         #######  ##     ##    ##     ######
+*/
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+/*
+##     ##    ###    ########   ######
+##     ##   ## ##   ##     ## ##    ##
+##     ##  ##   ##  ##     ## ##
+##     ## ##     ## ########   ######
+ ##   ##  ######### ##   ##         ##
+  ## ##   ##     ## ##    ##  ##    ##
+   ###    ##     ## ##     ##  ######
+*/
+
+var (
+	// Fixtures that are compiled into the binary
+	//go:embed fixtures/*.json
+	builtinFixtures embed.FS
+)
+
+/*
+######## ##    ## ########  ########  ######
+   ##     ##  ##  ##     ## ##       ##    ##
+   ##      ####   ##     ## ##       ##
+   ##       ##    ########  ######    ######
+   ##       ##    ##        ##             ##
+   ##       ##    ##        ##       ##    ##
+   ##       ##    ##        ########  ######
+*/
+
+// Fixture describes the network that is served by the mock XMC.
+type Fixture struct {
+	Devices []Device `json:"devices"`
+}
+
+// Device describes a single device managed by the mock XMC.
+// Field names follow the XMC NBI so that ports and VLANs can be returned as they are.
+type Device struct {
+	ID                  int        `json:"id"`
+	Up                  bool       `json:"up"`
+	IP                  string     `json:"ip"`
+	SysName             string     `json:"sysName"`
+	SysLocation         string     `json:"sysLocation"`
+	NickName            string     `json:"nickName"`
+	BaseMac             string     `json:"baseMac"`
+	SiteName            string     `json:"siteName"`
+	DeviceDisplayFamily string     `json:"deviceDisplayFamily"`
+	SubFamily           string     `json:"subFamily"`
+	LastUpdate          int64      `json:"lastUpdate"`
+	Ports               []Port     `json:"ports"`
+	Vlans               []Vlan     `json:"vlans"`
+	Neighbors           []Neighbor `json:"neighbors"`
+}
+
+// Port describes a single port of a device.
+type Port struct {
+	IfIndex       int      `json:"ifIndex"`
+	IfPhysAddress string   `json:"ifPhysAddress"`
+	IfName        string   `json:"ifName"`
+	IfAlias       string   `json:"ifAlias"`
+	IfDescr       string   `json:"ifDescr"`
+	IfAdminStatus string   `json:"ifAdminStatus"`
+	IfOperStatus  string   `json:"ifOperStatus"`
+	IfSpeed       int64    `json:"ifSpeed"`
+	Duplex        string   `json:"duplex"`
+	IfType        string   `json:"ifType"`
+	LagIfIndex    int      `json:"lagIfIndex"`
+	VlanList      []string `json:"vlanList"`
+}
+
+// Neighbor describes a single LLDP/CDP neighbor seen by a device.
+type Neighbor struct {
+	LocalIfIndex  int    `json:"localIfIndex"`
+	LocalPort     string `json:"localPort"`
+	RemoteSysName string `json:"remoteSysName"`
+	RemotePort    string `json:"remotePort"`
+	RemoteIP      string `json:"remoteIp"`
+	Protocol      string `json:"protocol"`
+}
+
+// Vlan describes a single VLAN configured on a device.
+type Vlan struct {
+	Type      string `json:"type"`
+	Vid       int    `json:"vid"`
+	Name      string `json:"name"`
+	PrimaryIP string `json:"primaryIp"`
+	Netmask   string `json:"netmask"`
+}
+
+/*
+######## ##     ## ##    ##  ######   ######
+##       ##     ## ###   ## ##    ## ##    ##
+##       ##     ## ####  ## ##       ##
+######   ##     ## ## ## ## ##        ######
+##       ##     ## ##  #### ##             ##
+##       ##     ## ##   ### ##    ## ##    ##
+##        #######  ##    ##  ######   ######
+*/
+
+// LoadFixture reads a fixture in JSON format.
+func LoadFixture(r io.Reader) (*Fixture, error) {
+	var fixture Fixture
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if jsonErr := decoder.Decode(&fixture); jsonErr != nil {
+		return nil, fmt.Errorf("could not decode fixture: %s", jsonErr)
+	}
+	seenIPs := make(map[string]bool)
+	for _, device := range fixture.Devices {
+		if device.IP == "" {
+			return nil, fmt.Errorf("fixture contains a device without IP")
+		}
+		if seenIPs[device.IP] {
+			return nil, fmt.Errorf("fixture contains device %s more than once", device.IP)
+		}
+		seenIPs[device.IP] = true
+	}
+
+	return &fixture, nil
+}
+
+// BuiltinFixtureNames returns the names of all fixtures compiled into the binary.
+func BuiltinFixtureNames() []string {
+	var names []string
+
+	entries, _ := builtinFixtures.ReadDir("fixtures")
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// BuiltinFixture returns one of the fixtures compiled into the binary.
+func BuiltinFixture(name string) (*Fixture, error) {
+	fixtureFile, openErr := builtinFixtures.Open(path.Join("fixtures", name+".json"))
+	if openErr != nil {
+		return nil, fmt.Errorf("unknown fixture <%s>, available are %s", name, strings.Join(BuiltinFixtureNames(), ", "))
+	}
+	defer fixtureFile.Close()
+	return LoadFixture(fixtureFile)
+}
+
+// OpenFixture returns the fixture stored in the given file or, if no such file exists, the builtin fixture of that name.
+func OpenFixture(nameOrFile string) (*Fixture, error) {
+	fixtureFile, openErr := os.Open(nameOrFile)
+	if os.IsNotExist(openErr) {
+		return BuiltinFixture(nameOrFile)
+	}
+	if openErr != nil {
+		return nil, fmt.Errorf("could not open fixture: %s", openErr)
+	}
+	defer fixtureFile.Close()
+	return LoadFixture(fixtureFile)
+}
@@ -0,0 +1,407 @@
+// Package mockxmc implements a fake XMC Northbound Interface for tests and demos.
+//
+// The server answers the OAuth token endpoint and the subset of GraphQL queries
+// and mutations used by VlanLister, based on a fixture that describes the network.
+// Queries are recognized by their shape instead of being parsed as GraphQL.
+package mockxmc
+
+/*
+#### ##     ## ########   #######  ########  ########  ######
+ ##  ###   ### ##     ## ##     ## ##     ##    ##    ##    ##
+ ##  #### #### ##     ## ##     ## ##     ##    ##    ##
+ ##  ## ### ## ########  ##     ## ########     ##     ######
+ ##  ##     ## ##        ##     ## ##   ##      ##          ##
+ ##  ##     ## ##        ##     ## ##    ##     ##    ##    ##
+#### ##     ## ##         #######  ##     ##    ##     ######
+*/
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ ######   #######  ##    ##  ######  ########    ###    ##    ## ########  ######
+##    ## ##     ## ###   ## ##    ##    ##      ## ##   ###   ##    ##    ##    ##
+##       ##     ## ####  ## ##          ##     ##   ##  ####  ##    ##    ##
+##       ##     ## ## ## ##  ######     ##    ##     ## ## ## ##    ##     ######
+##       ##     ## ##  ####       ##    ##    ######### ##  ####    ##          ##
+##    ## ##     ## ##   ### ##    ##    ##    ##     ## ##   ###    ##    ##    ##
+ ######   #######  ##    ##  ######     ##    ##     ## ##    ##    ##     ######
+*/
+
+const (
+	// TokenPath is the path of the OAuth token endpoint.
+	TokenPath string = "/oauth/token/access-token"
+	// APIPath is the path of the GraphQL endpoint.
+	APIPath string = "/nbi/graphql"
+	// Lifetime of issued OAuth tokens
+	tokenLifetime time.Duration = time.Hour
+	// MIME type of all responses
+	jsonMimeType string = "application/json"
+)
+
+/*
+##     ##    ###    ########   ######
+##     ##   ## ##   ##     ## ##    ##
+##     ##  ##   ##  ##     ## ##
+##     ## ##     ## ########   ######
+ ##   ##  ######### ##   ##         ##
+  ## ##   ##     ## ##    ##  ##    ##
+   ###    ##     ## ##     ##  ######
+*/
+
+var (
+	// Extracts the device IP from device and deviceVlans queries
+	deviceQueryRegex = regexp.MustCompile(`device\(ip:\s*"([^"]+)"\)`)
+	// Extracts the device IP from deviceNeighbors queries
+	neighborQueryRegex = regexp.MustCompile(`deviceNeighbors\(ip:\s*"([^"]+)"\)`)
+	// Extracts the device IPs from rediscoverDevices mutations
+	mutationDeviceRegex = regexp.MustCompile(`ipAddress:\s*"([^"]+)"`)
+)
+
+/*
+######## ##    ## ########  ########  ######
+   ##     ##  ##  ##     ## ##       ##    ##
+   ##      ####   ##     ## ##       ##
+   ##       ##    ########  ######    ######
+   ##       ##    ##        ##             ##
+   ##       ##    ##        ##       ##    ##
+   ##       ##    ##        ########  ######
+*/
+
+// Server is a fake XMC that serves the network described by a fixture.
+type Server struct {
+	// ClientID and Secret are the accepted credentials; any credentials are accepted if both are empty.
+	ClientID string
+	Secret   string
+	// Logf is called for every request if set.
+	Logf func(format string, args ...interface{})
+
+	mutex         sync.Mutex
+	devices       []Device
+	deviceErrors  map[string]int
+	rejectedIPs   map[string]bool
+	issuedTokens  map[string]bool
+	tokenRequests int
+	apiRequests   int
+	rediscovers   map[string]int
+}
+
+/*
+######## ##    ## ########  ########    ######## ##     ## ##    ##  ######   ######
+   ##     ##  ##  ##     ## ##          ##       ##     ## ###   ## ##    ## ##    ##
+   ##      ####   ##     ## ##          ##       ##     ## ####  ## ##       ##
+   ##       ##    ########  ######      ######   ##     ## ## ## ## ##        ######
+   ##       ##    ##        ##          ##       ##     ## ##  #### ##             ##
+   ##       ##    ##        ##          ##       ##     ## ##   ### ##    ## ##    ##
+   ##       ##    ##        ########    ##        #######  ##    ##  ######   ######
+*/
+
+// Handler returns the HTTP handler serving the token and GraphQL endpoints.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(TokenPath, s.serveToken)
+	mux.HandleFunc(APIPath, s.serveAPI)
+	return mux
+}
+
+// FailDevice makes all queries for the given device fail with the given HTTP status code.
+// A status code of 0 removes the failure again.
+func (s *Server) FailDevice(ip string, statusCode int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if statusCode == 0 {
+		delete(s.deviceErrors, ip)
+		return
+	}
+	s.deviceErrors[ip] = statusCode
+}
+
+// RejectRediscover makes all rediscover mutations containing the given device fail with status ERROR.
+// Passing false accepts rediscovers for the device again.
+func (s *Server) RejectRediscover(ip string, reject bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !reject {
+		delete(s.rejectedIPs, ip)
+		return
+	}
+	s.rejectedIPs[ip] = true
+}
+
+// Requests returns the number of requests to the token and the GraphQL endpoint.
+func (s *Server) Requests() (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.tokenRequests, s.apiRequests
+}
+
+// Rediscovers returns how often a rediscover has been triggered for the given device.
+func (s *Server) Rediscovers(ip string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.rediscovers[ip]
+}
+
+// Logs a request if logging is enabled
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// Checks the credentials used for the token endpoint or for Basic Auth
+func (s *Server) validCredentials(r *http.Request) bool {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	if s.ClientID == "" && s.Secret == "" {
+		return true
+	}
+	return clientID == s.ClientID && secret == s.Secret
+}
+
+// Checks the credentials used for the GraphQL endpoint
+func (s *Server) authorized(r *http.Request) bool {
+	authHeader := r.Header.Get("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.issuedTokens[strings.TrimPrefix(authHeader, "Bearer ")]
+	}
+	return s.validCredentials(r)
+}
+
+// Issues an OAuth token
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	s.tokenRequests++
+	s.mutex.Unlock()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.validCredentials(r) {
+		s.logf("Rejected token request with invalid credentials.\n")
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	token := newToken(time.Now().Add(tokenLifetime))
+	s.mutex.Lock()
+	s.issuedTokens[token] = true
+	s.mutex.Unlock()
+	s.logf("Issued OAuth token.\n")
+	writeJSON(w, map[string]string{"token_type": "Bearer", "access_token": token})
+}
+
+// Answers GraphQL queries and mutations
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Query string `json:"query"`
+	}
+
+	s.mutex.Lock()
+	s.apiRequests++
+	s.mutex.Unlock()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		s.logf("Rejected API request with invalid credentials.\n")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if jsonErr := json.NewDecoder(r.Body).Decode(&request); jsonErr != nil {
+		http.Error(w, "could not decode request", http.StatusBadRequest)
+		return
+	}
+
+	query := request.Query
+	switch {
+	case strings.Contains(query, "mutation"):
+		s.serveMutation(w, mutationDeviceRegex.FindAllStringSubmatch(query, -1))
+	case neighborQueryRegex.MatchString(query):
+		s.serveNeighbors(w, neighborQueryRegex.FindStringSubmatch(query)[1])
+	case deviceQueryRegex.MatchString(query):
+		s.serveDevice(w, deviceQueryRegex.FindStringSubmatch(query)[1])
+	case strings.Contains(query, "devices"):
+		s.serveDeviceList(w)
+	default:
+		s.logf("Received unsupported query.\n")
+		writeJSON(w, map[string]interface{}{"errors": []map[string]string{{"message": "unsupported query"}}})
+	}
+}
+
+// Answers a query for all devices, including their poll status
+func (s *Server) serveDeviceList(w http.ResponseWriter) {
+	var devices []map[string]interface{}
+
+	s.mutex.Lock()
+	for _, device := range s.devices {
+		devices = append(devices, map[string]interface{}{
+			"up":                  device.Up,
+			"ip":                  device.IP,
+			"sysName":             device.SysName,
+			"sysLocation":         device.SysLocation,
+			"siteName":            device.SiteName,
+			"deviceDisplayFamily": device.DeviceDisplayFamily,
+			"deviceData":          map[string]string{"subFamily": device.SubFamily},
+			"lastUpdate":          device.LastUpdate,
+		})
+	}
+	s.mutex.Unlock()
+
+	s.logf("Served list of %d devices.\n", len(devices))
+	writeJSON(w, map[string]interface{}{"data": map[string]interface{}{"network": map[string]interface{}{"devices": devices}}})
+}
+
+// Answers a query for the details of a single device
+func (s *Server) serveDevice(w http.ResponseWriter, ip string) {
+	var deviceData interface{}
+	vlans := []Vlan{}
+
+	s.mutex.Lock()
+	statusCode, failing := s.deviceErrors[ip]
+	for _, device := range s.devices {
+		if device.IP != ip {
+			continue
+		}
+		deviceData = map[string]interface{}{
+			"id":          device.ID,
+			"up":          device.Up,
+			"baseMac":     device.BaseMac,
+			"ip":          device.IP,
+			"sysName":     device.SysName,
+			"sysLocation": device.SysLocation,
+			"nickName":    device.NickName,
+			"entityData":  map[string]interface{}{"allPorts": device.Ports},
+		}
+		if device.Vlans != nil {
+			vlans = device.Vlans
+		}
+	}
+	s.mutex.Unlock()
+
+	if failing {
+		s.logf("Failing query for device %s with status code %d.\n", ip, statusCode)
+		http.Error(w, "simulated failure", statusCode)
+		return
+	}
+	s.logf("Served details of device %s.\n", ip)
+	writeJSON(w, map[string]interface{}{"data": map[string]interface{}{"network": map[string]interface{}{"device": deviceData, "deviceVlans": vlans}}})
+}
+
+// Answers a query for the LLDP/CDP neighbors of a single device
+func (s *Server) serveNeighbors(w http.ResponseWriter, ip string) {
+	neighbors := []Neighbor{}
+
+	s.mutex.Lock()
+	statusCode, failing := s.deviceErrors[ip]
+	for _, device := range s.devices {
+		if device.IP == ip && device.Neighbors != nil {
+			neighbors = device.Neighbors
+		}
+	}
+	s.mutex.Unlock()
+
+	if failing {
+		s.logf("Failing neighbor query for device %s with status code %d.\n", ip, statusCode)
+		http.Error(w, "simulated failure", statusCode)
+		return
+	}
+	s.logf("Served %d neighbor(s) of device %s.\n", len(neighbors), ip)
+	writeJSON(w, map[string]interface{}{"data": map[string]interface{}{"network": map[string]interface{}{"deviceNeighbors": neighbors}}})
+}
+
+// Triggers a rediscover, which immediately results in a fresh poll of the devices
+func (s *Server) serveMutation(w http.ResponseWriter, matches [][]string) {
+	var unknownIPs []string
+	var rejectedIPs []string
+
+	s.mutex.Lock()
+	for _, match := range matches {
+		if s.rejectedIPs[match[1]] {
+			rejectedIPs = append(rejectedIPs, match[1])
+		}
+	}
+	s.mutex.Unlock()
+	if len(rejectedIPs) > 0 {
+		s.logf("Rejecting rediscover of %d device(s) due to %s.\n", len(matches), strings.Join(rejectedIPs, ", "))
+		writeJSON(w, map[string]interface{}{"data": map[string]interface{}{"network": map[string]interface{}{"rediscoverDevices": map[string]string{"status": "ERROR", "message": fmt.Sprintf("Rediscover rejected for %s", strings.Join(rejectedIPs, ", "))}}}})
+		return
+	}
+
+	s.mutex.Lock()
+	pollTime := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, match := range matches {
+		known := false
+		for i := range s.devices {
+			if s.devices[i].IP == match[1] {
+				known = true
+				s.devices[i].LastUpdate = pollTime
+				s.rediscovers[match[1]]++
+			}
+		}
+		if !known {
+			unknownIPs = append(unknownIPs, match[1])
+		}
+	}
+	s.mutex.Unlock()
+
+	result := map[string]string{"status": "SUCCESS", "message": ""}
+	if len(unknownIPs) > 0 {
+		result = map[string]string{"status": "ERROR", "message": fmt.Sprintf("Unknown device(s): %s", strings.Join(unknownIPs, ", "))}
+	}
+	s.logf("Rediscover of %d device(s) resulted in %s.\n", len(matches), result["status"])
+	writeJSON(w, map[string]interface{}{"data": map[string]interface{}{"network": map[string]interface{}{"rediscoverDevices": result}}})
+}
+
+/*
+######## ##     ## ##    ##  ######   ######
+##       ##     ## ###   ## ##    ## ##    ##
+##       ##     ## ####  ## ##       ##
+######   ##     ## ## ## ## ##        ######
+##       ##     ## ##  #### ##             ##
+##       ##     ## ##   ### ##    ## ##    ##
+##        #######  ##    ##  ######   ######
+*/
+
+// New returns a server for the given fixture. The fixture is copied, so the server can modify its state freely.
+func New(fixture *Fixture) *Server {
+	s := &Server{
+		deviceErrors: make(map[string]int),
+		rejectedIPs:  make(map[string]bool),
+		issuedTokens: make(map[string]bool),
+		rediscovers:  make(map[string]int),
+	}
+	s.devices = append(s.devices, fixture.Devices...)
+	return s
+}
+
+// Returns an unsigned token in the format used by XMC
+func newToken(expiresAt time.Time) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"iss":"mockxmc","sub":"mock","iat":%d,"exp":%d,"jti":"%d"}`, time.Now().Unix(), expiresAt.Unix(), time.Now().UnixNano())))
+	signature := base64.RawURLEncoding.EncodeToString([]byte("mockxmc"))
+	return fmt.Sprintf("%s.%s.%s", header, payload, signature)
+}
+
+// Writes a JSON response
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", jsonMimeType)
+	if encodeErr := json.NewEncoder(w).Encode(data); encodeErr != nil {
+		http.Error(w, "could not encode response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,174 @@
+package mockxmc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Sends a GraphQL query to the server and decodes the response
+func query(t *testing.T, server *httptest.Server, token string, gql string, result interface{}) int {
+	body, _ := json.Marshal(map[string]string{"query": gql})
+	request, _ := http.NewRequest(http.MethodPost, server.URL+APIPath, strings.NewReader(string(body)))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		request.SetBasicAuth("client", "secret")
+	}
+	response, responseErr := http.DefaultClient.Do(request)
+	if responseErr != nil {
+		t.Fatalf("request failed: %s", responseErr)
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusOK && result != nil {
+		if jsonErr := json.NewDecoder(response.Body).Decode(result); jsonErr != nil {
+			t.Fatalf("could not decode response: %s", jsonErr)
+		}
+	}
+	return response.StatusCode
+}
+
+// Starts a server for the builtin campus fixture
+func startServer(t *testing.T) (*Server, *httptest.Server) {
+	fixture, fixtureErr := BuiltinFixture("campus")
+	if fixtureErr != nil {
+		t.Fatalf("BuiltinFixture() returned error: %s", fixtureErr)
+	}
+	mock := New(fixture)
+	mock.ClientID = "client"
+	mock.Secret = "secret"
+	server := httptest.NewServer(mock.Handler())
+	t.Cleanup(server.Close)
+	return mock, server
+}
+
+func TestBuiltinFixtures(t *testing.T) {
+	names := BuiltinFixtureNames()
+	if len(names) == 0 {
+		t.Fatalf("BuiltinFixtureNames() returned no fixtures")
+	}
+	for _, name := range names {
+		if _, fixtureErr := BuiltinFixture(name); fixtureErr != nil {
+			t.Errorf("BuiltinFixture(%q) returned error: %s", name, fixtureErr)
+		}
+	}
+	if _, fixtureErr := BuiltinFixture("missing"); fixtureErr == nil {
+		t.Errorf("BuiltinFixture(%q) did not return an error", "missing")
+	}
+}
+
+func TestLoadFixtureDuplicateDevice(t *testing.T) {
+	_, fixtureErr := LoadFixture(strings.NewReader(`{"devices":[{"ip":"192.0.2.1"},{"ip":"192.0.2.1"}]}`))
+	if fixtureErr == nil {
+		t.Errorf("LoadFixture() did not return an error for a duplicate device")
+	}
+}
+
+func TestTokenEndpoint(t *testing.T) {
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+
+	_, server := startServer(t)
+
+	request, _ := http.NewRequest(http.MethodPost, server.URL+TokenPath, nil)
+	request.SetBasicAuth("client", "wrong")
+	response, _ := http.DefaultClient.Do(request)
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("token endpoint returned %d for wrong credentials, want %d", response.StatusCode, http.StatusUnauthorized)
+	}
+
+	request, _ = http.NewRequest(http.MethodPost, server.URL+TokenPath, nil)
+	request.SetBasicAuth("client", "secret")
+	response, _ = http.DefaultClient.Do(request)
+	json.NewDecoder(response.Body).Decode(&token)
+	response.Body.Close()
+	if token.AccessToken == "" {
+		t.Fatalf("token endpoint did not return a token")
+	}
+
+	if statusCode := query(t, server, token.AccessToken, "query { network { devices { ip } } }", nil); statusCode != http.StatusOK {
+		t.Errorf("API returned %d for a valid token, want %d", statusCode, http.StatusOK)
+	}
+	if statusCode := query(t, server, "invalid", "query { network { devices { ip } } }", nil); statusCode != http.StatusUnauthorized {
+		t.Errorf("API returned %d for an invalid token, want %d", statusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestDeviceQueries(t *testing.T) {
+	var deviceList struct {
+		Data struct {
+			Network struct {
+				Devices []Device `json:"devices"`
+			} `json:"network"`
+		} `json:"data"`
+	}
+	var deviceData struct {
+		Data struct {
+			Network struct {
+				Device      *Device `json:"device"`
+				DeviceVlans []Vlan  `json:"deviceVlans"`
+			} `json:"network"`
+		} `json:"data"`
+	}
+
+	mock, server := startServer(t)
+
+	query(t, server, "", "query { network { devices { up ip } } }", &deviceList)
+	if len(deviceList.Data.Network.Devices) != 4 {
+		t.Errorf("device list contains %d device(s), want 4", len(deviceList.Data.Network.Devices))
+	}
+
+	query(t, server, "", `query { network { device(ip: "10.0.0.1") { id } deviceVlans(ip: "10.0.0.1") { vid } } }`, &deviceData)
+	if deviceData.Data.Network.Device == nil || deviceData.Data.Network.Device.SysName != "core-sw1" {
+		t.Errorf("device query returned %+v, want core-sw1", deviceData.Data.Network.Device)
+	}
+	if len(deviceData.Data.Network.DeviceVlans) != 5 {
+		t.Errorf("device query returned %d VLAN(s), want 5", len(deviceData.Data.Network.DeviceVlans))
+	}
+
+	mock.FailDevice("10.0.0.1", http.StatusInternalServerError)
+	if statusCode := query(t, server, "", `query { network { device(ip: "10.0.0.1") { id } } }`, nil); statusCode != http.StatusInternalServerError {
+		t.Errorf("device query returned %d for a failing device, want %d", statusCode, http.StatusInternalServerError)
+	}
+}
+
+func TestRediscoverMutation(t *testing.T) {
+	var mutation struct {
+		Data struct {
+			Network struct {
+				RediscoverDevices struct {
+					Status  string `json:"status"`
+					Message string `json:"message"`
+				} `json:"rediscoverDevices"`
+			} `json:"network"`
+		} `json:"data"`
+	}
+
+	mock, server := startServer(t)
+
+	query(t, server, "", `mutation { network { rediscoverDevices(input: {devices: [{ipAddress: "10.0.0.1"}, {ipAddress: "10.0.0.2"}]}) { status } } }`, &mutation)
+	if mutation.Data.Network.RediscoverDevices.Status != "SUCCESS" {
+		t.Errorf("mutation returned status %q, want SUCCESS", mutation.Data.Network.RediscoverDevices.Status)
+	}
+	if mock.Rediscovers("10.0.0.1") != 1 || mock.Rediscovers("10.0.0.2") != 1 {
+		t.Errorf("mutation did not count rediscovers for both devices")
+	}
+
+	query(t, server, "", `mutation { network { rediscoverDevices(input: {devices: [{ipAddress: "192.0.2.99"}]}) { status } } }`, &mutation)
+	if mutation.Data.Network.RediscoverDevices.Status != "ERROR" {
+		t.Errorf("mutation returned status %q for an unknown device, want ERROR", mutation.Data.Network.RediscoverDevices.Status)
+	}
+
+	mock.RejectRediscover("10.0.0.2", true)
+	query(t, server, "", `mutation { network { rediscoverDevices(input: {devices: [{ipAddress: "10.0.0.1"}, {ipAddress: "10.0.0.2"}]}) { status } } }`, &mutation)
+	if mutation.Data.Network.RediscoverDevices.Status != "ERROR" {
+		t.Errorf("mutation returned status %q for a rejected device, want ERROR", mutation.Data.Network.RediscoverDevices.Status)
+	}
+	if mock.Rediscovers("10.0.0.1") != 1 {
+		t.Errorf("rejected mutation counted a rediscover for 10.0.0.1")
+	}
+}